@@ -6,6 +6,7 @@ import (
 	"flag"
 	"fmt"
 	"grpc_youtube_tutorial/client"
+	"grpc_youtube_tutorial/memory"
 	"grpc_youtube_tutorial/pb"
 	"grpc_youtube_tutorial/sample"
 	"io"
@@ -43,6 +44,41 @@ func createLaptop(laptopClient pb.LaptopServiceClient, laptop *pb.Laptop) {
 	log.Printf("laptop created with id %v", res.GetId())
 }
 
+func createLaptops(laptopClient pb.LaptopServiceClient, laptops []*pb.Laptop, allOrNothing bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := laptopClient.CreateLaptops(ctx)
+	if err != nil {
+		log.Fatal("cannot create laptops: ", err)
+	}
+
+	for _, laptop := range laptops {
+		req := &pb.CreateLaptopsRequest{
+			Laptop:       laptop,
+			AllOrNothing: allOrNothing,
+		}
+
+		err := stream.Send(req)
+		if err != nil {
+			log.Fatal("cannot send laptop: ", err, stream.RecvMsg(nil))
+		}
+	}
+
+	res, err := stream.CloseAndRecv()
+	if err != nil {
+		log.Fatal("cannot receive response: ", err)
+	}
+
+	for _, result := range res.GetResults() {
+		if codes.Code(result.GetCode()) != codes.OK {
+			log.Printf("laptop %v not created: %v", result.GetId(), result.GetMessage())
+		}
+	}
+
+	log.Printf("created %v laptops, %v failed", res.GetCreatedCount(), res.GetFailedCount())
+}
+
 func searchLaptop(laptopClient pb.LaptopServiceClient, filter *pb.Filter) {
 	log.Print("search filter: ", filter)
 
@@ -65,7 +101,8 @@ func searchLaptop(laptopClient pb.LaptopServiceClient, filter *pb.Filter) {
 		} else if err != nil {
 			log.Fatal("error while receiving stream: ", err)
 		} else {
-			log.Print("~ found: ", res.GetLaptop().GetName())
+			laptop := res.GetLaptop()
+			log.Printf("~ found: %v, ram: %v", laptop.GetName(), memory.Format(laptop.GetRam()))
 		}
 	}
 
@@ -75,6 +112,15 @@ func testCreateLaptop(laptopClient pb.LaptopServiceClient) {
 	createLaptop(laptopClient, sample.NewLaptop())
 }
 
+func testCreateLaptops(laptopClient pb.LaptopServiceClient) {
+	laptops := make([]*pb.Laptop, 10)
+	for i := range laptops {
+		laptops[i] = sample.NewLaptop()
+	}
+
+	createLaptops(laptopClient, laptops, false)
+}
+
 func testSearchLaptop(laptopClient pb.LaptopServiceClient) {
 	for i := 0; i < 10; i++ {
 		createLaptop(laptopClient, sample.NewLaptop())
@@ -143,13 +189,160 @@ func uploadImage(laptopClient pb.LaptopServiceClient, laptopID string, imagePath
 		log.Fatal("cannot recieve response: ", err)
 	}
 
-	log.Printf("image uploaded with id: %v, size: %v", res.GetId(), res.GetSize())
+	log.Printf("image uploaded with id: %v, size: %v, checksum: %v", res.GetId(), res.GetSize(), res.GetChecksum())
+}
+
+// uploadImageResumable uploads an image in a resumable session, resuming from the
+// bytes received by the server whenever the upload stream is interrupted
+func uploadImageResumable(laptopClient pb.LaptopServiceClient, laptopID string, imagePath string, maxAttempts int) {
+	file, err := os.Open(imagePath)
+	if err != nil {
+		log.Fatal("cannot open image file: ", err)
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		log.Fatal("cannot stat image file: ", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	startReq := &pb.StartImageUploadRequest{
+		Info: &pb.ImageInfo{
+			LaptopId:  laptopID,
+			ImageType: filepath.Ext(imagePath),
+		},
+		Size: stat.Size(),
+	}
+
+	startRes, err := laptopClient.StartImageUpload(ctx, startReq)
+	if err != nil {
+		log.Fatal("cannot start image upload: ", err)
+	}
+
+	sessionID := startRes.GetSessionId()
+	log.Printf("image upload session started with id: %v", sessionID)
+
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		statusRes, err := laptopClient.GetUploadStatus(ctx, &pb.GetUploadStatusRequest{SessionId: sessionID})
+		if err != nil {
+			log.Fatal("cannot get upload status: ", err)
+		}
+
+		res, err := uploadImageFrom(ctx, laptopClient, sessionID, file, statusRes.GetReceivedBytes())
+		if err == nil {
+			log.Printf("image uploaded with id: %v, size: %v, checksum: %v", res.GetId(), res.GetSize(), res.GetChecksum())
+			return
+		}
+
+		log.Printf("upload attempt %v interrupted: %v", attempt, err)
+	}
+
+	log.Fatalf("cannot upload image after %v attempts", maxAttempts)
+}
+
+// uploadImageFrom sends the image file of an upload session starting at offset
+func uploadImageFrom(ctx context.Context, laptopClient pb.LaptopServiceClient, sessionID string, file *os.File, offset int64) (*pb.UploadImageResponse, error) {
+	_, err := file.Seek(offset, io.SeekStart)
+	if err != nil {
+		return nil, fmt.Errorf("cannot seek image file: %v", err)
+	}
+
+	stream, err := laptopClient.UploadImage(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot upload image file: %v", err)
+	}
+
+	reader := bufio.NewReader(file)
+	buffer := make([]byte, 1024)
+
+	for {
+		n, err := reader.Read(buffer)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot read chunk to buffer: %v", err)
+		}
+
+		req := &pb.UploadImageRequest{
+			Data: &pb.UploadImageRequest_Chunk{
+				Chunk: buffer[:n],
+			},
+			SessionId: sessionID,
+			Offset:    offset,
+		}
+
+		err = stream.Send(req)
+		if err != nil {
+			return nil, fmt.Errorf("cannot send chunk to server: %v - %v", err, stream.RecvMsg(nil))
+		}
+
+		offset += int64(n)
+	}
+
+	return stream.CloseAndRecv()
+}
+
+func downloadImage(laptopClient pb.LaptopServiceClient, imageID string, imagePath string) {
+	// resume from the end of a previously interrupted download
+	file, err := os.OpenFile(imagePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		log.Fatal("cannot open image file: ", err)
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		log.Fatal("cannot stat image file: ", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req := &pb.DownloadImageRequest{
+		ImageId: imageID,
+		Offset:  stat.Size(),
+	}
+
+	stream, err := laptopClient.DownloadImage(ctx, req)
+	if err != nil {
+		log.Fatal("cannot download image: ", err)
+	}
+
+	res, err := stream.Recv()
+	if err != nil {
+		log.Fatal("cannot receive image info: ", err)
+	}
+
+	info := res.GetInfo()
+	log.Printf("downloading image %v of laptop %v from offset %v, size: %v", info.GetImageId(), info.GetLaptopId(), req.GetOffset(), info.GetSize())
+
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatal("cannot receive chunk: ", err)
+		}
+
+		_, err = file.Write(res.GetChunk())
+		if err != nil {
+			log.Fatal("cannot write chunk to file: ", err)
+		}
+	}
+
+	log.Printf("image downloaded to: %v", imagePath)
 }
 
 func testUploadImage(laptopClient pb.LaptopServiceClient) {
 	laptop := sample.NewLaptop()
 	createLaptop(laptopClient, laptop)
 	uploadImage(laptopClient, laptop.GetId(), "tmp/laptop.jpg")
+	uploadImageResumable(laptopClient, laptop.GetId(), "tmp/laptop.jpg", 3)
 }
 
 const (
@@ -162,9 +355,20 @@ func authMethods() map[string]bool {
 	const laptopServicePath = "/techschool.pcbook.LaptopService/"
 
 	return map[string]bool{
-		laptopServicePath + "CreateLaptop": true,
-		laptopServicePath + "UploadImage":  true,
-		laptopServicePath + "RateLaptop":   true,
+		laptopServicePath + "CreateLaptop":       true,
+		laptopServicePath + "CreateLaptops":      true,
+		laptopServicePath + "UploadImage":        true,
+		laptopServicePath + "StartImageUpload":   true,
+		laptopServicePath + "GetUploadStatus":    true,
+		laptopServicePath + "DeleteImage":        true,
+		laptopServicePath + "SetPrimaryImage":    true,
+		laptopServicePath + "ReorderImages":      true,
+		laptopServicePath + "RateLaptop":         true,
+		laptopServicePath + "DeleteReview":       true,
+		laptopServicePath + "ListPendingReviews": true,
+		laptopServicePath + "ModerateReview":     true,
+		laptopServicePath + "ListMyReviews":      true,
+		laptopServicePath + "ListRatingAudit":    true,
 	}
 }
 
@@ -227,6 +431,10 @@ func rateLaptop(laptopClient pb.LaptopServiceClient, laptopIDs []string, scores
 				waitResponse <- fmt.Errorf("cannot receive stream response: %v", err)
 				return
 			}
+			if codes.Code(res.GetCode()) != codes.OK {
+				log.Printf("laptop %v not rated: %v", res.GetLaptopId(), res.GetMessage())
+				continue
+			}
 			log.Printf("recieved response: %v", res)
 		}
 	}()
@@ -7,12 +7,17 @@ import (
 	"grpc_youtube_tutorial/service"
 	"log"
 	"net"
+	"strings"
 	"time"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/reflection"
 )
 
+// seededUserCreatedAt is the creation time of the seeded users,
+// so that the rating limits don't treat them as new accounts every time the server starts
+var seededUserCreatedAt = time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
 func seedUsers(userStore service.UserStore) error {
 	err := createUser(userStore, "admin1", "secret", "admin")
 	if err != nil {
@@ -26,6 +31,7 @@ func createUser(userStore service.UserStore, username, password, role string) er
 	if err != nil {
 		return err
 	}
+	user.CreatedAt = seededUserCreatedAt
 
 	return userStore.Save(user)
 }
@@ -34,14 +40,51 @@ func accessibleRoles() map[string][]string {
 	const laptopServicePath = "/techschool.pcbook.LaptopService/"
 
 	return map[string][]string{
-		laptopServicePath + "CreateLaptop": {"admin"},
-		laptopServicePath + "UploadImage":  {"admin"},
-		laptopServicePath + "RateLaptop":   {"admin", "user"},
+		laptopServicePath + "CreateLaptop":       {"admin"},
+		laptopServicePath + "CreateLaptops":      {"admin"},
+		laptopServicePath + "UploadImage":        {"admin"},
+		laptopServicePath + "StartImageUpload":   {"admin"},
+		laptopServicePath + "GetUploadStatus":    {"admin"},
+		laptopServicePath + "DeleteImage":        {"admin"},
+		laptopServicePath + "SetPrimaryImage":    {"admin"},
+		laptopServicePath + "ReorderImages":      {"admin"},
+		laptopServicePath + "RateLaptop":         {"admin", "user"},
+		laptopServicePath + "DeleteReview":       {"admin", "user"},
+		laptopServicePath + "ListPendingReviews": {"admin"},
+		laptopServicePath + "ModerateReview":     {"admin"},
+		laptopServicePath + "ListMyReviews":      {"admin", "user"},
+		laptopServicePath + "ListRatingAudit":    {"admin"},
+	}
+}
+
+// splitWords splits a comma separated list of words, trimming them and leaving out the empty ones
+func splitWords(list string) []string {
+	var words []string
+	for _, word := range strings.Split(list, ",") {
+		word = strings.TrimSpace(word)
+		if len(word) > 0 {
+			words = append(words, word)
+		}
 	}
+	return words
 }
 
 func main() {
 	port := flag.Int("port", 0, "the server port")
+	maxImageSize := flag.Int64("max-image-size", 1<<20, "the maximum size of uploaded images in bytes")
+	maxAllOrNothingLaptops := flag.Int("max-all-or-nothing-laptops", 1000, "the maximum number of laptops created all or nothing in a stream")
+	ratingPriorScore := flag.Float64("rating-prior-score", 5.5, "the score top rated laptops are pulled toward")
+	ratingPriorWeight := flag.Float64("rating-prior-weight", 10, "the number of prior scores counted with the ratings of top rated laptops")
+	ratingMinVotes := flag.Uint("rating-min-votes", 1, "the number of ratings a laptop needs to be top rated")
+	ratingRecentDays := flag.Uint("rating-recent-days", 30, "the number of days ratings count in the recent average, 0 for all")
+	ratingHalfLife := flag.Duration("rating-half-life", 90*24*time.Hour, "the age at which a score weighs half in the decayed score, 0 for no decay")
+	ratingStep := flag.Float64("rating-step", 0, "the granularity of rating scores, like 0.5 for half steps, 0 for any score")
+	ratingUserLimit := flag.Int("rating-user-limit", 30, "the number of ratings a user can give per minute, 0 for no limit")
+	ratingBurstLimit := flag.Int("rating-burst-limit", 10, "the number of new accounts that can rate a laptop in 10 minutes, 0 for no limit")
+	ratingNewAccountAge := flag.Duration("rating-new-account-age", 24*time.Hour, "the age under which accounts are new for the rating burst limit")
+	bannedWords := flag.String("review-banned-words", "", "comma separated words that reviews cannot contain")
+	maxImagePixels := flag.Int64("max-image-pixels", 50000000, "the maximum width times height of uploaded images")
+	stripImageMetadata := flag.Bool("strip-image-metadata", true, "remove EXIF and other metadata from uploaded images")
 	flag.Parse()
 	log.Printf("start server on port %v", *port)
 
@@ -55,9 +98,40 @@ func main() {
 	authSever := service.NewAuthServer(userStore, jwtManager)
 
 	laptopStore := service.NewInMemoryLaptopStore()
-	imageStore := service.NewDiskImageStore("img")
+	imageStore, err := service.NewDiskImageStore("img")
+	if err != nil {
+		log.Fatalf("unable to load image store: %v", err)
+	}
+	imageStore.StripMetadata = *stripImageMetadata
+	imageStore.MaxImagePixels = *maxImagePixels
+
+	report, err := imageStore.Reconcile()
+	if err != nil {
+		log.Fatalf("unable to reconcile image store: %v", err)
+	}
+	for _, name := range report.FilesWithoutMetadata {
+		log.Printf("image file without metadata: %v", name)
+	}
+	for _, imageID := range report.MetadataWithoutFiles {
+		log.Printf("image metadata without file: %v", imageID)
+	}
 	ratingStore := service.NewInMemoryRatingStore()
+	ratingStore.PriorScore = *ratingPriorScore
+	ratingStore.PriorWeight = *ratingPriorWeight
+	ratingStore.MinVotes = uint32(*ratingMinVotes)
+	ratingStore.RecentWindow = time.Duration(*ratingRecentDays) * 24 * time.Hour
+	ratingStore.HalfLife = *ratingHalfLife
 	laptopServer := service.NewLaptopServer(laptopStore, imageStore, ratingStore)
+	laptopServer.MaxImageSize = *maxImageSize
+	laptopServer.MaxAllOrNothingLaptops = *maxAllOrNothingLaptops
+	laptopServer.RatingScale.Step = *ratingStep
+	laptopServer.RatingLimiter = service.NewRatingLimiter()
+	laptopServer.RatingLimiter.UserLimit = *ratingUserLimit
+	laptopServer.RatingLimiter.BurstLimit = *ratingBurstLimit
+	laptopServer.RatingLimiter.NewAccountAge = *ratingNewAccountAge
+	if words := splitWords(*bannedWords); len(words) > 0 {
+		laptopServer.ReviewValidators = append(laptopServer.ReviewValidators, service.ProfanityValidator(words...))
+	}
 
 	interceptor := service.NewAuthInterceptor(jwtManager, accessibleRoles())
 	grpcServer := grpc.NewServer(
@@ -0,0 +1,146 @@
+package memory
+
+import (
+	"errors"
+	"fmt"
+	"grpc_youtube_tutorial/pb"
+	"math"
+	"strconv"
+)
+
+// CanonicalUnit is the unit memories are stored in
+const CanonicalUnit = pb.Memory_MEGABYTE
+
+// ErrUnknownUnit for memories without a unit
+var ErrUnknownUnit = errors.New("unknown memory unit")
+
+// bits is the number of bits in one of each unit, as a power of 2
+var bits = map[pb.Memory_Unit]uint{
+	pb.Memory_BIT:      0,
+	pb.Memory_BYTE:     3,  // 8 = 2^3
+	pb.Memory_KILOBYTE: 13, // 1024 = 2^10 8 = 2^3
+	pb.Memory_MEGABYTE: 23,
+	pb.Memory_GIGABYTE: 33,
+	pb.Memory_TERABYTE: 43,
+}
+
+// symbols are the short names used when formatting memories
+var symbols = map[pb.Memory_Unit]string{
+	pb.Memory_BIT:      "bit",
+	pb.Memory_BYTE:     "B",
+	pb.Memory_KILOBYTE: "KB",
+	pb.Memory_MEGABYTE: "MB",
+	pb.Memory_GIGABYTE: "GB",
+	pb.Memory_TERABYTE: "TB",
+}
+
+// ToBit returns the size of memory in bits, 0 if the unit is unknown
+func ToBit(memory *pb.Memory) uint64 {
+	shift, ok := bits[memory.GetUnit()]
+	if !ok {
+		return 0
+	}
+
+	return uint64(memory.GetValue()) << shift
+}
+
+// Compare returns -1, 0 or 1 if memory a is smaller than, equal to or larger than memory b
+func Compare(a *pb.Memory, b *pb.Memory) int {
+	aBits, bBits := ToBit(a), ToBit(b)
+
+	switch {
+	case aBits < bBits:
+		return -1
+	case aBits > bBits:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Convert returns memory expressed in unit. It fails if the unit is unknown, or if
+// memory isn't a whole number of unit or doesn't fit in it.
+func Convert(memory *pb.Memory, unit pb.Memory_Unit) (*pb.Memory, error) {
+	from, ok := bits[memory.GetUnit()]
+	if !ok {
+		return nil, ErrUnknownUnit
+	}
+
+	to, ok := bits[unit]
+	if !ok {
+		return nil, ErrUnknownUnit
+	}
+
+	value := memory.GetValue()
+	if from >= to {
+		if value > math.MaxUint32>>(from-to) {
+			return nil, fmt.Errorf("%s is too large to be expressed in %s", Format(memory), symbols[unit])
+		}
+		value <<= from - to
+	} else {
+		if value%(1<<(to-from)) != 0 {
+			return nil, fmt.Errorf("%s is not a whole number of %s", Format(memory), symbols[unit])
+		}
+		value >>= to - from
+	}
+
+	return &pb.Memory{
+		Value:       value,
+		Unit:        unit,
+		DisplayUnit: memory.GetDisplayUnit(),
+	}, nil
+}
+
+// Normalize returns memory in CanonicalUnit, keeping its unit as the display unit.
+// Memory that isn't a whole number of CanonicalUnit, like a CPU cache of 512 KB, is rounded up.
+func Normalize(memory *pb.Memory) (*pb.Memory, error) {
+	other, err := Convert(roundUp(memory, CanonicalUnit), CanonicalUnit)
+	if err != nil {
+		return nil, err
+	}
+
+	if other.DisplayUnit == pb.Memory_UNKNOWN {
+		other.DisplayUnit = memory.GetUnit()
+	}
+
+	return other, nil
+}
+
+// roundUp returns memory rounded up to a whole number of unit, in unit if it's smaller than unit
+func roundUp(memory *pb.Memory, unit pb.Memory_Unit) *pb.Memory {
+	from, ok := bits[memory.GetUnit()]
+	to, toOk := bits[unit]
+	if !ok || !toOk || from >= to {
+		return memory
+	}
+
+	step := uint32(1) << (to - from)
+	value := memory.GetValue() / step
+	if memory.GetValue()%step != 0 {
+		value++
+	}
+
+	return &pb.Memory{
+		Value:       value,
+		Unit:        unit,
+		DisplayUnit: memory.GetDisplayUnit(),
+	}
+}
+
+// Format returns a human-readable size such as "16 GB", in the display unit if it has one
+func Format(memory *pb.Memory) string {
+	unit := memory.GetDisplayUnit()
+	if _, ok := bits[unit]; !ok {
+		unit = memory.GetUnit()
+	}
+
+	shift, ok := bits[unit]
+	if !ok {
+		return strconv.FormatUint(uint64(memory.GetValue()), 10)
+	}
+
+	value := float64(ToBit(memory)) / float64(uint64(1)<<shift)
+	value = math.Round(value*100) / 100
+
+	return fmt.Sprintf("%s %s", strconv.FormatFloat(value, 'f', -1, 64), symbols[unit])
+}
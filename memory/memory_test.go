@@ -0,0 +1,98 @@
+package memory_test
+
+import (
+	"grpc_youtube_tutorial/memory"
+	"grpc_youtube_tutorial/pb"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalize(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		memory   *pb.Memory
+		expected *pb.Memory
+		display  string
+		// rounded is the display of memories rounded up by Normalize
+		rounded string
+	}{
+		{
+			name:     "gigabyte",
+			memory:   &pb.Memory{Value: 16, Unit: pb.Memory_GIGABYTE},
+			expected: &pb.Memory{Value: 16384, Unit: pb.Memory_MEGABYTE, DisplayUnit: pb.Memory_GIGABYTE},
+			display:  "16 GB",
+		}, {
+			name:     "megabyte",
+			memory:   &pb.Memory{Value: 16384, Unit: pb.Memory_MEGABYTE},
+			expected: &pb.Memory{Value: 16384, Unit: pb.Memory_MEGABYTE, DisplayUnit: pb.Memory_MEGABYTE},
+			display:  "16384 MB",
+		}, {
+			name:     "kilobyte",
+			memory:   &pb.Memory{Value: 2048, Unit: pb.Memory_KILOBYTE},
+			expected: &pb.Memory{Value: 2, Unit: pb.Memory_MEGABYTE, DisplayUnit: pb.Memory_KILOBYTE},
+			display:  "2048 KB",
+		}, {
+			name:     "not_whole_megabytes",
+			memory:   &pb.Memory{Value: 1000, Unit: pb.Memory_KILOBYTE},
+			expected: &pb.Memory{Value: 1, Unit: pb.Memory_MEGABYTE, DisplayUnit: pb.Memory_KILOBYTE},
+			display:  "1000 KB",
+			rounded:  "1024 KB",
+		}, {
+			name:     "cache",
+			memory:   &pb.Memory{Value: 512, Unit: pb.Memory_KILOBYTE},
+			expected: &pb.Memory{Value: 1, Unit: pb.Memory_MEGABYTE, DisplayUnit: pb.Memory_KILOBYTE},
+			display:  "512 KB",
+			rounded:  "1024 KB",
+		}, {
+			name:     "bits",
+			memory:   &pb.Memory{Value: 8388609, Unit: pb.Memory_BIT},
+			expected: &pb.Memory{Value: 2, Unit: pb.Memory_MEGABYTE, DisplayUnit: pb.Memory_BIT},
+			display:  "8388609 bit",
+			rounded:  "16777216 bit",
+		}, {
+			name:    "unknown_unit",
+			memory:  &pb.Memory{Value: 16, Unit: pb.Memory_UNKNOWN},
+			display: "16",
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			require.Equal(t, tc.display, memory.Format(tc.memory))
+
+			normalized, err := memory.Normalize(tc.memory)
+			if tc.expected == nil {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tc.expected.GetValue(), normalized.GetValue())
+			require.Equal(t, tc.expected.GetUnit(), normalized.GetUnit())
+			require.Equal(t, tc.expected.GetDisplayUnit(), normalized.GetDisplayUnit())
+
+			if len(tc.rounded) > 0 {
+				require.Equal(t, -1, memory.Compare(tc.memory, normalized))
+				require.Equal(t, tc.rounded, memory.Format(normalized))
+				return
+			}
+
+			require.Equal(t, 0, memory.Compare(tc.memory, normalized))
+			require.Equal(t, tc.display, memory.Format(normalized))
+		})
+	}
+}
+
+func TestFormat(t *testing.T) {
+	t.Parallel()
+
+	m := &pb.Memory{Value: 1536, Unit: pb.Memory_GIGABYTE, DisplayUnit: pb.Memory_TERABYTE}
+	require.Equal(t, "1.5 TB", memory.Format(m))
+}
@@ -0,0 +1,53 @@
+package service
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Field numbers of laptop and primary_image_id in pb.SearchLaptopResponse
+const (
+	searchLaptopResponseLaptopField         = 1
+	searchLaptopResponsePrimaryImageIDField = 2
+)
+
+// encodedSearchLaptopResponse is a pb.SearchLaptopResponse that is already in wire format.
+// gRPC's proto codec calls its Marshal method instead of encoding the message again.
+type encodedSearchLaptopResponse []byte
+
+// newEncodedSearchLaptopResponse wraps an encoded laptop and its primary image Id into an encoded search response
+func newEncodedSearchLaptopResponse(laptop []byte, primaryImageID string) encodedSearchLaptopResponse {
+	size := protowire.SizeTag(searchLaptopResponseLaptopField) + protowire.SizeBytes(len(laptop))
+	if len(primaryImageID) > 0 {
+		size += protowire.SizeTag(searchLaptopResponsePrimaryImageIDField) + protowire.SizeBytes(len(primaryImageID))
+	}
+
+	res := make([]byte, 0, size)
+	res = protowire.AppendTag(res, searchLaptopResponseLaptopField, protowire.BytesType)
+	res = protowire.AppendBytes(res, laptop)
+
+	// like the generated code, the empty string default is not encoded
+	if len(primaryImageID) > 0 {
+		res = protowire.AppendTag(res, searchLaptopResponsePrimaryImageIDField, protowire.BytesType)
+		res = protowire.AppendString(res, primaryImageID)
+	}
+
+	return res
+}
+
+// Marshal returns the encoded response
+func (res encodedSearchLaptopResponse) Marshal() ([]byte, error) {
+	return res, nil
+}
+
+// Reset is a no-op, the encoded response is immutable
+func (res encodedSearchLaptopResponse) Reset() {}
+
+// String returns a short description of the encoded response
+func (res encodedSearchLaptopResponse) String() string {
+	return fmt.Sprintf("encoded search laptop response (%d bytes)", len(res))
+}
+
+// ProtoMessage marks encodedSearchLaptopResponse as a protobuf message
+func (res encodedSearchLaptopResponse) ProtoMessage() {}
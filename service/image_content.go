@@ -0,0 +1,103 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"net/http"
+	"os"
+	"strings"
+
+	// register the decoders of the accepted image formats
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// ErrInvalidImage for images that are not of an accepted format or don't match their type
+var ErrInvalidImage = errors.New("invalid image")
+
+// imageExtensions maps the accepted image formats to the image types they can be uploaded as
+var imageExtensions = map[string][]string{
+	"jpeg": {".jpg", ".jpeg"},
+	"png":  {".png"},
+	"gif":  {".gif"},
+}
+
+// normalizeImageType returns the lower case image type if it's an accepted extension
+func normalizeImageType(imageType string) (string, error) {
+	imageType = strings.ToLower(imageType)
+
+	for _, extensions := range imageExtensions {
+		for _, extension := range extensions {
+			if imageType == extension {
+				return imageType, nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("%w: image type %q is not accepted", ErrInvalidImage, imageType)
+}
+
+// inspectImage sniffs the content type of the image file at path and decodes it,
+// checking that it's an image of imageType with at most maxPixels pixels. It returns the decoded image and its format.
+func inspectImage(path string, imageType string, maxPixels int64) (image.Image, string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("cannot open image file: %v", err)
+	}
+	defer file.Close()
+
+	// DetectContentType considers at most 512 bytes
+	header := make([]byte, 512)
+	n, err := file.Read(header)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: cannot read image header: %v", ErrInvalidImage, err)
+	}
+
+	contentType := http.DetectContentType(header[:n])
+	sniffed := strings.TrimPrefix(contentType, "image/")
+	if sniffed == contentType || imageExtensions[sniffed] == nil {
+		return nil, "", fmt.Errorf("%w: content type %v is not accepted", ErrInvalidImage, contentType)
+	}
+
+	_, err = file.Seek(0, 0)
+	if err != nil {
+		return nil, "", fmt.Errorf("cannot seek image file: %v", err)
+	}
+
+	// the dimensions in the header are checked first, as decoding allocates memory for all of the pixels
+	config, format, err := image.DecodeConfig(file)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: cannot decode image header: %v", ErrInvalidImage, err)
+	}
+
+	if config.Width <= 0 || config.Height <= 0 || int64(config.Width)*int64(config.Height) > maxPixels {
+		return nil, "", fmt.Errorf("%w: image of %vx%v pixels is larger than %v pixels",
+			ErrInvalidImage, config.Width, config.Height, maxPixels)
+	}
+
+	_, err = file.Seek(0, 0)
+	if err != nil {
+		return nil, "", fmt.Errorf("cannot seek image file: %v", err)
+	}
+
+	img, format, err := image.Decode(file)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: cannot decode image: %v", ErrInvalidImage, err)
+	}
+
+	if format != sniffed {
+		return nil, "", fmt.Errorf("%w: decoded format %v doesn't match content type %v", ErrInvalidImage, format, contentType)
+	}
+
+	matches := false
+	for _, extension := range imageExtensions[format] {
+		matches = matches || extension == imageType
+	}
+	if !matches {
+		return nil, "", fmt.Errorf("%w: %v image uploaded as %v", ErrInvalidImage, format, imageType)
+	}
+
+	return img, format, nil
+}
@@ -0,0 +1,103 @@
+package service
+
+import (
+	"errors"
+)
+
+// ErrInvalidImageOrder for image orders that don't list every image of the laptop exactly once
+var ErrInvalidImageOrder = errors.New("image order must list every image of the laptop exactly once")
+
+// Primary returns the information of the first image of the laptop with the Id of laptopID
+func (store *DiskImageStore) Primary(laptopID string) (*ImageInfo, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	imageIDs := store.laptopImages[laptopID]
+	if len(imageIDs) == 0 {
+		return nil, ErrNotFound
+	}
+
+	other := *store.images[imageIDs[0]]
+	return &other, nil
+}
+
+// SetPrimary moves the image with the Id of imageID to the front of its laptop's images
+func (store *DiskImageStore) SetPrimary(imageID string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	info := store.images[imageID]
+	if info == nil {
+		return ErrNotFound
+	}
+
+	imageIDs := []string{imageID}
+	for _, id := range store.laptopImages[info.LaptopID] {
+		if id != imageID {
+			imageIDs = append(imageIDs, id)
+		}
+	}
+
+	return store.reorder(info.LaptopID, imageIDs)
+}
+
+// Reorder sets the order of the images of the laptop with the Id of laptopID,
+// imageIDs must list every image of the laptop exactly once
+func (store *DiskImageStore) Reorder(laptopID string, imageIDs []string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	current := store.laptopImages[laptopID]
+	if len(current) == 0 {
+		return ErrNotFound
+	}
+
+	if len(imageIDs) != len(current) {
+		return ErrInvalidImageOrder
+	}
+
+	listed := make(map[string]bool, len(imageIDs))
+	for _, imageID := range imageIDs {
+		info := store.images[imageID]
+		if info == nil || info.LaptopID != laptopID || listed[imageID] {
+			return ErrInvalidImageOrder
+		}
+		listed[imageID] = true
+	}
+
+	return store.reorder(laptopID, imageIDs)
+}
+
+// reorder saves the position of every image in the metadata files, then updates the index.
+// It must be called with the write lock held.
+func (store *DiskImageStore) reorder(laptopID string, imageIDs []string) error {
+	var written []*ImageInfo
+
+	for position, imageID := range imageIDs {
+		info := store.images[imageID]
+		if info.Position == position {
+			continue
+		}
+
+		other := *info
+		other.Position = position
+
+		err := store.writeMetadata(&other)
+		if err != nil {
+			// best effort to keep the order on disk as it was
+			for _, info := range written {
+				store.writeMetadata(store.images[info.ID])
+			}
+			return err
+		}
+
+		written = append(written, &other)
+	}
+
+	for _, info := range written {
+		store.images[info.ID] = info
+	}
+	store.laptopImages[laptopID] = append([]string(nil), imageIDs...)
+
+	return nil
+}
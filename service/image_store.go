@@ -1,71 +1,711 @@
 package service
 
 import (
-	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
 )
 
-// Maximage size is 1 mb
-const maxImageSize = 1 << 20
+// metadataExt is the extension of the sidecar files holding image metadata
+const metadataExt = ".meta"
+
+// tmpExt is the extension of files that are still being written
+const tmpExt = ".tmp"
 
 // ImageStore is an interface to store laptop images
 type ImageStore interface {
-	Save(laptopID string, imageType string, image bytes.Buffer) (string, error)
+	// Save stores the image read from image and returns its Id
+	Save(laptopID string, imageType string, uploader string, image io.Reader) (string, error)
+	// Create starts writing a new image, which is only stored once the writer is committed
+	Create(laptopID string, imageType string, uploader string) (ImageWriter, error)
+	// Find returns the information of the image with the Id of imageID
+	Find(imageID string) (*ImageInfo, error)
+	// Open opens the image with the Id of imageID for reading
+	Open(imageID string) (ImageReader, error)
+	// OpenThumbnail opens the thumbnail of the given size of the image with the Id of imageID for reading
+	OpenThumbnail(imageID string, size int) (ImageReader, error)
+	// List returns the information of all images of the laptop with the Id of laptopID
+	List(laptopID string) ([]*ImageInfo, error)
+	// Primary returns the information of the first image of the laptop with the Id of laptopID
+	Primary(laptopID string) (*ImageInfo, error)
+	// SetPrimary moves the image with the Id of imageID to the front of its laptop's images
+	SetPrimary(imageID string) error
+	// Reorder sets the order of the images of the laptop with the Id of laptopID
+	Reorder(laptopID string, imageIDs []string) error
+	// Delete removes the image with the Id of imageID
+	Delete(imageID string) error
+	// StartUpload starts a resumable upload of an image of size bytes
+	StartUpload(laptopID string, imageType string, uploader string, size int64) (*UploadSession, error)
+	// FindUpload returns the state of the upload session with the Id of sessionID
+	FindUpload(sessionID string) (*UploadSession, error)
+	// WriteUpload appends chunk to the upload session, it must be written at the received offset
+	WriteUpload(sessionID string, offset int64, chunk []byte) (*UploadSession, error)
+	// CompleteUpload stores the fully received image of the upload session
+	CompleteUpload(sessionID string) (*ImageInfo, error)
+}
+
+// ImageReader reads the content of a stored image
+type ImageReader interface {
+	io.ReadSeeker
+	io.Closer
 }
 
-// DiskImageStore stores image on disk and it's information on memory
+// ImageWriter writes the content of a new image
+type ImageWriter interface {
+	io.Writer
+	// Commit stores the written image and returns its information
+	Commit() (*ImageInfo, error)
+	// Abort discards the written image, it's a no-op once the writer is committed
+	Abort() error
+}
+
+// DiskImageStore stores image on disk and it's information on memory,
+// with a metadata sidecar file for every image. Image files are named by the SHA-256
+// of their content, so images with the same content share a file.
 type DiskImageStore struct {
 	mutex       sync.RWMutex
 	imageFolder string
 	images      map[string]*ImageInfo
+	// laptopImages indexes image IDs by laptop ID, in upload order
+	laptopImages map[string][]string
+	uploads      map[string]*uploadSession
+	// blobs indexes the stored image files by path
+	blobs map[string]*imageBlob
+
+	// UploadExpiry is how long an upload session is kept without receiving data
+	UploadExpiry time.Duration
+	// ThumbnailSizes are the longest sides, in pixels, of the thumbnails generated for every image
+	ThumbnailSizes []int
+	// StripMetadata removes the metadata, like EXIF and comments, from JPEG and PNG images before storing them
+	StripMetadata bool
+	// MaxImagePixels is the largest width times height of the images accepted, checked before decoding them
+	MaxImagePixels int64
+}
+
+// Default upload session expiry is 24 hours
+const defaultUploadExpiry = 24 * time.Hour
+
+// Default maximum image size is 50 megapixels, about 200 mb decoded
+const defaultMaxImagePixels = 50000000
+
+// imageBlob is an image file, with its thumbnails, shared by all images with the same content
+type imageBlob struct {
+	// refs is the number of images referencing the file
+	refs       int
+	width      int
+	height     int
+	thumbnails []ThumbnailInfo
 }
 
 // ImageInfo contains information about the laptop image
 type ImageInfo struct {
-	LaptopID string
-	Type     string
-	Path     string
+	ID        string    `json:"id"`
+	LaptopID  string    `json:"laptop_id"`
+	Type      string    `json:"type"`
+	Path      string    `json:"-"`
+	Size      int64     `json:"size"`
+	Checksum  string    `json:"checksum"`
+	Width     int       `json:"width"`
+	Height    int       `json:"height"`
+	Uploader  string    `json:"uploader"`
+	CreatedAt time.Time `json:"created_at"`
+	// Position is the place of the image among the images of its laptop, the first one is the primary image
+	Position int `json:"position"`
+	// Thumbnails are the scaled down copies of the image, in the order of the store thumbnail sizes
+	Thumbnails []ThumbnailInfo `json:"thumbnails"`
+}
+
+// Thumbnail returns the thumbnail of the given size, if the image has one
+func (info *ImageInfo) Thumbnail(size int) (*ThumbnailInfo, bool) {
+	for i := range info.Thumbnails {
+		if info.Thumbnails[i].Size == size {
+			return &info.Thumbnails[i], true
+		}
+	}
+	return nil, false
+}
+
+// ReconcileReport lists the differences between the image files and their metadata
+type ReconcileReport struct {
+	// FilesWithoutMetadata are the names of files in the image folder that belong to no image
+	FilesWithoutMetadata []string
+	// MetadataWithoutFiles are the IDs of images whose file is missing
+	MetadataWithoutFiles []string
+}
+
+// NewDiskImageStore returns a new image store, loaded with the images already in imageFolder
+func NewDiskImageStore(imageFolder string) (*DiskImageStore, error) {
+	store := &DiskImageStore{
+		imageFolder:    imageFolder,
+		images:         make(map[string]*ImageInfo),
+		laptopImages:   make(map[string][]string),
+		uploads:        make(map[string]*uploadSession),
+		blobs:          make(map[string]*imageBlob),
+		UploadExpiry:   defaultUploadExpiry,
+		ThumbnailSizes: defaultThumbnailSizes,
+		MaxImagePixels: defaultMaxImagePixels,
+	}
+
+	err := store.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// load reads the metadata sidecar files in the image folder and indexes the images that have a file
+func (store *DiskImageStore) load() error {
+	names, err := filepath.Glob(filepath.Join(store.imageFolder, "*"+metadataExt))
+	if err != nil {
+		return fmt.Errorf("cannot list image metadata: %v", err)
+	}
+
+	var images []*ImageInfo
+	for _, name := range names {
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			return fmt.Errorf("cannot read image metadata: %v", err)
+		}
+
+		info := &ImageInfo{}
+		err = json.Unmarshal(data, info)
+		if err != nil {
+			return fmt.Errorf("cannot parse image metadata %v: %v", name, err)
+		}
+
+		info.Path = store.blobPath(info.Checksum, info.Type)
+		for i := range info.Thumbnails {
+			thumbnail := &info.Thumbnails[i]
+			thumbnail.Path = store.thumbnailPath(info.Checksum, thumbnail.Size, info.Type)
+		}
+
+		err = store.migrateLegacyFiles(info)
+		if err != nil {
+			return err
+		}
+
+		_, err = os.Stat(info.Path)
+		if os.IsNotExist(err) {
+			continue
+		}
+
+		images = append(images, info)
+	}
+
+	sort.Slice(images, func(i, j int) bool {
+		if images[i].Position != images[j].Position {
+			return images[i].Position < images[j].Position
+		}
+		return images[i].CreatedAt.Before(images[j].CreatedAt)
+	})
+
+	for _, info := range images {
+		store.index(info)
+	}
+
+	return nil
+}
+
+// migrateLegacyFiles moves the files of an image stored before they were named by content.
+// If the content is already stored, the legacy files are removed.
+func (store *DiskImageStore) migrateLegacyFiles(info *ImageInfo) error {
+	err := migrateFile(store.imagePath(info.ID, info.Type), info.Path)
+	if err != nil {
+		return err
+	}
+
+	for _, thumbnail := range info.Thumbnails {
+		err = migrateFile(store.thumbnailPath(info.ID, thumbnail.Size, info.Type), thumbnail.Path)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func migrateFile(legacyPath string, path string) error {
+	_, err := os.Stat(legacyPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	_, err = os.Stat(path)
+	if err == nil {
+		err = os.Remove(legacyPath)
+	} else {
+		err = os.Rename(legacyPath, path)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot migrate image file %v: %v", legacyPath, err)
+	}
+
+	return nil
 }
 
-// NewDiskImageStore returns a new image store
-func NewDiskImageStore(imageFolder string) *DiskImageStore {
-	return &DiskImageStore{
-		imageFolder: imageFolder,
-		images:      make(map[string]*ImageInfo),
+// Reconcile compares the files in the image folder with the image metadata
+func (store *DiskImageStore) Reconcile() (*ReconcileReport, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	entries, err := ioutil.ReadDir(store.imageFolder)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list image folder: %v", err)
 	}
+
+	files := make(map[string]bool)
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			files[entry.Name()] = true
+		}
+	}
+
+	report := &ReconcileReport{}
+
+	for name := range files {
+		if strings.HasSuffix(name, tmpExt) || store.uploads[strings.TrimSuffix(name, partExt)] != nil {
+			// still being written
+			delete(files, name)
+			continue
+		}
+		if !strings.HasSuffix(name, metadataExt) {
+			continue
+		}
+		delete(files, name)
+
+		imageID := strings.TrimSuffix(name, metadataExt)
+		info := store.images[imageID]
+		if info == nil || !files[filepath.Base(info.Path)] {
+			report.MetadataWithoutFiles = append(report.MetadataWithoutFiles, imageID)
+		}
+	}
+
+	for _, info := range store.images {
+		delete(files, filepath.Base(info.Path))
+		for _, thumbnail := range info.Thumbnails {
+			delete(files, filepath.Base(thumbnail.Path))
+		}
+	}
+
+	for name := range files {
+		report.FilesWithoutMetadata = append(report.FilesWithoutMetadata, name)
+	}
+
+	sort.Strings(report.FilesWithoutMetadata)
+	sort.Strings(report.MetadataWithoutFiles)
+
+	return report, nil
 }
 
 // Save methods saves given file on disk
-func (store *DiskImageStore) Save(laptopID string, imageType string, image bytes.Buffer) (string, error) {
+func (store *DiskImageStore) Save(laptopID string, imageType string, uploader string, image io.Reader) (string, error) {
+	writer, err := store.Create(laptopID, imageType, uploader)
+	if err != nil {
+		return "", err
+	}
+	defer writer.Abort()
+
+	_, err = io.Copy(writer, image)
+	if err != nil {
+		return "", fmt.Errorf("cannot write image: %v", err)
+	}
+
+	info, err := writer.Commit()
+	if err != nil {
+		return "", err
+	}
+
+	return info.ID, nil
+}
+
+// Create starts writing a new image to a temporary file in the image folder
+func (store *DiskImageStore) Create(laptopID string, imageType string, uploader string) (ImageWriter, error) {
+	imageType, err := normalizeImageType(imageType)
+	if err != nil {
+		return nil, err
+	}
+
 	imageID, err := uuid.NewRandom()
 	if err != nil {
-		return "", fmt.Errorf("cannot create image id: %v", err)
+		return nil, fmt.Errorf("cannot create image id: %v", err)
+	}
+
+	file, err := os.Create(store.imagePath(imageID.String(), imageType) + tmpExt)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create image file: %v", err)
+	}
+
+	writer := &diskImageWriter{
+		store: store,
+		file:  file,
+		hash:  sha256.New(),
+		info: &ImageInfo{
+			ID:       imageID.String(),
+			LaptopID: laptopID,
+			Type:     imageType,
+			Uploader: uploader,
+		},
+	}
+
+	return writer, nil
+}
+
+// diskImageWriter writes an image to a temporary file, which is stored by content on commit
+type diskImageWriter struct {
+	store *DiskImageStore
+	file  *os.File
+	hash  hash.Hash
+	info  *ImageInfo
+	done  bool
+}
+
+// Write writes a chunk of the image
+func (writer *diskImageWriter) Write(chunk []byte) (int, error) {
+	n, err := writer.file.Write(chunk)
+	writer.hash.Write(chunk[:n])
+	writer.info.Size += int64(n)
+	return n, err
+}
+
+// Commit stores the image file by content and saves its metadata
+func (writer *diskImageWriter) Commit() (*ImageInfo, error) {
+	if writer.done {
+		return nil, fmt.Errorf("image writer is already closed")
+	}
+	writer.done = true
+
+	tmpPath := writer.file.Name()
+	err := writer.file.Close()
+	if err != nil {
+		os.Remove(tmpPath)
+		return nil, fmt.Errorf("cannot close image file: %v", err)
+	}
+
+	info := writer.info
+	info.Checksum = hex.EncodeToString(writer.hash.Sum(nil))
+
+	err = writer.store.add(tmpPath, info)
+	if err != nil {
+		return nil, err
 	}
 
-	imagePath := fmt.Sprintf("%s/%s%s", store.imageFolder, imageID, imageType)
+	other := *info
+	return &other, nil
+}
+
+// Abort removes the temporary image file
+func (writer *diskImageWriter) Abort() error {
+	if writer.done {
+		return nil
+	}
+	writer.done = true
 
-	file, err := os.Create(imagePath)
+	writer.file.Close()
+	return os.Remove(writer.file.Name())
+}
+
+// Find returns the information of the image with the Id of imageID
+func (store *DiskImageStore) Find(imageID string) (*ImageInfo, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	info := store.images[imageID]
+	if info == nil {
+		return nil, ErrNotFound
+	}
+
+	other := *info
+	return &other, nil
+}
+
+// Open opens the image with the Id of imageID for reading
+func (store *DiskImageStore) Open(imageID string) (ImageReader, error) {
+	info, err := store.Find(imageID)
 	if err != nil {
-		return "", fmt.Errorf("cannot create image file: %v", err)
+		return nil, err
 	}
 
-	_, err = image.WriteTo(file)
+	file, err := os.Open(info.Path)
 	if err != nil {
-		return "", fmt.Errorf("cannot create image err: %v", err)
+		return nil, fmt.Errorf("cannot open image file: %v", err)
+	}
+
+	return file, nil
+}
+
+// OpenThumbnail opens the thumbnail of the given size of the image with the Id of imageID for reading
+func (store *DiskImageStore) OpenThumbnail(imageID string, size int) (ImageReader, error) {
+	info, err := store.Find(imageID)
+	if err != nil {
+		return nil, err
+	}
+
+	thumbnail, ok := info.Thumbnail(size)
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	file, err := os.Open(thumbnail.Path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open thumbnail file: %v", err)
+	}
+
+	return file, nil
+}
+
+// List returns the information of all images of the laptop with the Id of laptopID
+func (store *DiskImageStore) List(laptopID string) ([]*ImageInfo, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	imageIDs := store.laptopImages[laptopID]
+	images := make([]*ImageInfo, len(imageIDs))
+	for i, imageID := range imageIDs {
+		other := *store.images[imageID]
+		images[i] = &other
 	}
 
+	return images, nil
+}
+
+// Delete removes the image with the Id of imageID, its file and its metadata
+func (store *DiskImageStore) Delete(imageID string) error {
 	store.mutex.Lock()
 	defer store.mutex.Unlock()
 
-	store.images[imageID.String()] = &ImageInfo{
-		LaptopID: laptopID,
-		Type:     imageType,
-		Path:     imagePath,
+	info := store.images[imageID]
+	if info == nil {
+		return ErrNotFound
+	}
+
+	// the metadata is removed first, so a failure leaves the image in place. Once it's gone the image
+	// is deleted, and its file is removed with the last image referencing it: a file left behind
+	// belongs to no image and is reported by Reconcile.
+	err := os.Remove(store.metadataPath(imageID))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("cannot remove image metadata: %v", err)
+	}
+
+	blob := store.blobs[info.Path]
+	blob.refs--
+	if blob.refs == 0 {
+		os.Remove(info.Path)
+		removeThumbnails(blob.thumbnails, "")
+		delete(store.blobs, info.Path)
+	}
+
+	delete(store.images, imageID)
+
+	imageIDs := store.laptopImages[info.LaptopID]
+	for i, id := range imageIDs {
+		if id == imageID {
+			imageIDs = append(imageIDs[:i:i], imageIDs[i+1:]...)
+			break
+		}
+	}
+
+	if len(imageIDs) == 0 {
+		delete(store.laptopImages, info.LaptopID)
+	} else {
+		store.laptopImages[info.LaptopID] = imageIDs
+	}
+
+	return nil
+}
+
+// add strips the metadata of the written image file if enabled, checks it and stores it by content,
+// unless the same content is already stored, then saves the image metadata and indexes it
+func (store *DiskImageStore) add(tmpPath string, info *ImageInfo) error {
+	if store.StripMetadata {
+		err := stripStoredMetadata(tmpPath, info)
+		if err != nil {
+			os.Remove(tmpPath)
+			return err
+		}
+	}
+
+	info.Path = store.blobPath(info.Checksum, info.Type)
+	info.CreatedAt = time.Now()
+
+	var blob *imageBlob
+	var thumbnails []ThumbnailInfo
+	prepared := false
+
+	for {
+		store.mutex.Lock()
+		blob = store.blobs[info.Path]
+		if blob != nil || prepared {
+			break
+		}
+		store.mutex.Unlock()
+
+		// new content is checked and its thumbnails generated without holding the lock
+		img, format, err := inspectImage(tmpPath, info.Type, store.MaxImagePixels)
+		if err != nil {
+			os.Remove(tmpPath)
+			return err
+		}
+
+		info.Width = img.Bounds().Dx()
+		info.Height = img.Bounds().Dy()
+
+		thumbnails, err = store.writeThumbnails(info, img, format)
+		if err != nil {
+			os.Remove(tmpPath)
+			return err
+		}
+
+		prepared = true
+	}
+	defer store.mutex.Unlock()
+
+	if blob != nil {
+		os.Remove(tmpPath)
+		removeThumbnails(thumbnails, thumbnailTmpSuffix(info))
+
+		info.Width = blob.width
+		info.Height = blob.height
+		info.Thumbnails = blob.thumbnails
+	} else {
+		err := moveBlob(tmpPath, info.Path, thumbnails, thumbnailTmpSuffix(info))
+		if err != nil {
+			return err
+		}
+
+		info.Thumbnails = thumbnails
+		blob = &imageBlob{width: info.Width, height: info.Height, thumbnails: thumbnails}
+	}
+
+	info.Position = store.nextPosition(info.LaptopID)
+
+	err := store.writeMetadata(info)
+	if err != nil {
+		if blob.refs == 0 {
+			os.Remove(info.Path)
+			removeThumbnails(thumbnails, "")
+		}
+		return err
+	}
+
+	store.blobs[info.Path] = blob
+	store.index(info)
+
+	return nil
+}
+
+// stripStoredMetadata strips the metadata from the image file at path, and updates the size and checksum of the image
+func stripStoredMetadata(path string, info *ImageInfo) error {
+	err := stripImageMetadata(path, info.Type)
+	if err != nil {
+		return err
+	}
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("cannot stat image file: %v", err)
+	}
+
+	info.Size = stat.Size()
+	info.Checksum, err = fileChecksum(path)
+	return err
+}
+
+// moveBlob moves the temporary image file, and the thumbnail files with their paths ending with tmpSuffix, into place
+func moveBlob(tmpPath string, path string, thumbnails []ThumbnailInfo, tmpSuffix string) error {
+	err := os.Rename(tmpPath, path)
+	if err != nil {
+		os.Remove(tmpPath)
+		removeThumbnails(thumbnails, tmpSuffix)
+		return fmt.Errorf("cannot move image file: %v", err)
+	}
+
+	for i, thumbnail := range thumbnails {
+		err = os.Rename(thumbnail.Path+tmpSuffix, thumbnail.Path)
+		if err != nil {
+			os.Remove(path)
+			removeThumbnails(thumbnails[:i], "")
+			removeThumbnails(thumbnails[i:], tmpSuffix)
+			return fmt.Errorf("cannot move thumbnail file: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// index adds the image to the in memory indexes, it must be called with the write lock held
+func (store *DiskImageStore) index(info *ImageInfo) {
+	blob := store.blobs[info.Path]
+	if blob == nil {
+		blob = &imageBlob{width: info.Width, height: info.Height, thumbnails: info.Thumbnails}
+		store.blobs[info.Path] = blob
+	}
+	blob.refs++
+
+	store.images[info.ID] = info
+	store.laptopImages[info.LaptopID] = append(store.laptopImages[info.LaptopID], info.ID)
+}
+
+// nextPosition returns the position of a new image of the laptop, after all its images.
+// It must be called with the lock held.
+func (store *DiskImageStore) nextPosition(laptopID string) int {
+	imageIDs := store.laptopImages[laptopID]
+	if len(imageIDs) == 0 {
+		return 0
+	}
+	return store.images[imageIDs[len(imageIDs)-1]].Position + 1
+}
+
+func (store *DiskImageStore) imagePath(imageID string, imageType string) string {
+	return fmt.Sprintf("%s/%s%s", store.imageFolder, imageID, imageType)
+}
+
+// blobPath returns the path of the image file with the given content checksum
+func (store *DiskImageStore) blobPath(checksum string, imageType string) string {
+	return fmt.Sprintf("%s/%s%s", store.imageFolder, checksum, imageType)
+}
+
+// thumbnailPath returns the path of the thumbnail file of the image file with the given name
+func (store *DiskImageStore) thumbnailPath(name string, size int, imageType string) string {
+	return fmt.Sprintf("%s/%s_%d%s", store.imageFolder, name, size, imageType)
+}
+
+func (store *DiskImageStore) metadataPath(imageID string) string {
+	return fmt.Sprintf("%s/%s%s", store.imageFolder, imageID, metadataExt)
+}
+
+// writeMetadata writes the sidecar file of the image, replacing it atomically
+func (store *DiskImageStore) writeMetadata(info *ImageInfo) error {
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return fmt.Errorf("cannot marshal image metadata: %v", err)
+	}
+
+	path := store.metadataPath(info.ID)
+	tmpPath := path + tmpExt
+
+	err = ioutil.WriteFile(tmpPath, data, 0644)
+	if err != nil {
+		return fmt.Errorf("cannot write image metadata: %v", err)
+	}
+
+	err = os.Rename(tmpPath, path)
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("cannot write image metadata: %v", err)
 	}
 
-	return imageID.String(), nil
+	return nil
 }
@@ -0,0 +1,519 @@
+package service_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"grpc_youtube_tutorial/service"
+	"hash/crc32"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiskImageStoreReload(t *testing.T) {
+	t.Parallel()
+
+	imageStore, imageFolder := newTestImageStore(t)
+	laptopID := uuid.New().String()
+
+	contents := make([][]byte, 3)
+	imageIDs := make([]string, 3)
+	for i := range imageIDs {
+		contents[i] = newTestPNG(t, 40+i, 30)
+
+		var err error
+		imageIDs[i], err = imageStore.Save(laptopID, ".png", "admin1", bytes.NewReader(contents[i]))
+		require.NoError(t, err)
+	}
+
+	// image file without metadata, and metadata without image file
+	orphan := filepath.Join(imageFolder, uuid.New().String()+".png")
+	require.NoError(t, ioutil.WriteFile(orphan, []byte("orphan"), 0644))
+
+	missing, err := imageStore.Find(imageIDs[2])
+	require.NoError(t, err)
+	require.NoError(t, os.Remove(missing.Path))
+
+	reloaded, err := service.NewDiskImageStore(imageFolder)
+	require.NoError(t, err)
+
+	images, err := reloaded.List(laptopID)
+	require.NoError(t, err)
+	require.Len(t, images, 2)
+	for i, info := range images {
+		require.Equal(t, imageIDs[i], info.ID)
+		require.Equal(t, laptopID, info.LaptopID)
+		require.Equal(t, "admin1", info.Uploader)
+		require.Equal(t, int64(len(contents[i])), info.Size)
+		require.False(t, info.CreatedAt.IsZero())
+	}
+
+	_, err = reloaded.Find(imageIDs[2])
+	require.Equal(t, service.ErrNotFound, err)
+
+	report, err := reloaded.Reconcile()
+	require.NoError(t, err)
+	require.Equal(t, []string{filepath.Base(orphan)}, report.FilesWithoutMetadata)
+	require.Equal(t, []string{imageIDs[2]}, report.MetadataWithoutFiles)
+}
+
+func TestDiskImageStoreDeduplicatesContent(t *testing.T) {
+	t.Parallel()
+
+	imageStore, imageFolder := newTestImageStore(t)
+	content := newTestPNG(t, 600, 300)
+
+	imageIDs := make([]string, 3)
+	for i := range imageIDs {
+		var err error
+		imageIDs[i], err = imageStore.Save(uuid.New().String(), ".png", "admin1", bytes.NewReader(content))
+		require.NoError(t, err)
+	}
+
+	first, err := imageStore.Find(imageIDs[0])
+	require.NoError(t, err)
+
+	checksum := sha256.Sum256(content)
+	require.Equal(t, hex.EncodeToString(checksum[:]), first.Checksum)
+	require.Equal(t, filepath.Join(imageFolder, first.Checksum+".png"), first.Path)
+
+	for _, imageID := range imageIDs[1:] {
+		info, err := imageStore.Find(imageID)
+		require.NoError(t, err)
+		require.Equal(t, first.Path, info.Path)
+		require.Equal(t, first.Thumbnails, info.Thumbnails)
+	}
+
+	// one image file with its 2 thumbnails, and a metadata file for every image
+	files, err := ioutil.ReadDir(imageFolder)
+	require.NoError(t, err)
+	require.Len(t, files, 3+len(imageIDs))
+
+	reloaded, err := service.NewDiskImageStore(imageFolder)
+	require.NoError(t, err)
+
+	for i, imageID := range imageIDs {
+		require.NoError(t, reloaded.Delete(imageID))
+
+		if i < len(imageIDs)-1 {
+			require.FileExists(t, first.Path)
+			for _, thumbnail := range first.Thumbnails {
+				require.FileExists(t, thumbnail.Path)
+			}
+		}
+	}
+
+	files, err = ioutil.ReadDir(imageFolder)
+	require.NoError(t, err)
+	require.Empty(t, files)
+}
+
+func TestDiskImageStoreDeleteFailure(t *testing.T) {
+	t.Parallel()
+
+	imageStore, imageFolder := newTestImageStore(t)
+
+	imageID, err := imageStore.Save(uuid.New().String(), ".png", "admin1", bytes.NewReader(newTestPNG(t, 60, 30)))
+	require.NoError(t, err)
+
+	info, err := imageStore.Find(imageID)
+	require.NoError(t, err)
+
+	// a metadata file that can't be removed
+	metadataPath := filepath.Join(imageFolder, imageID+".meta")
+	metadata, err := ioutil.ReadFile(metadataPath)
+	require.NoError(t, err)
+	require.NoError(t, os.Remove(metadataPath))
+	require.NoError(t, os.MkdirAll(filepath.Join(metadataPath, "busy"), 0755))
+
+	// the image is still there, with its file
+	require.Error(t, imageStore.Delete(imageID))
+
+	found, err := imageStore.Find(imageID)
+	require.NoError(t, err)
+	require.Equal(t, info.Path, found.Path)
+	require.FileExists(t, info.Path)
+
+	require.NoError(t, os.RemoveAll(metadataPath))
+	require.NoError(t, ioutil.WriteFile(metadataPath, metadata, 0644))
+
+	require.NoError(t, imageStore.Delete(imageID))
+
+	_, err = imageStore.Find(imageID)
+	require.Equal(t, service.ErrNotFound, err)
+
+	files, err := ioutil.ReadDir(imageFolder)
+	require.NoError(t, err)
+	require.Empty(t, files)
+}
+
+func TestDiskImageStoreConcurrentSameContent(t *testing.T) {
+	t.Parallel()
+
+	imageStore, imageFolder := newTestImageStore(t)
+	content := newTestPNG(t, 600, 300)
+
+	// the uploads write their thumbnails at the same time, none of them may overwrite another's
+	const uploads = 16
+	imageIDs := make([]string, uploads)
+	errs := make([]error, uploads)
+
+	var wg sync.WaitGroup
+	for i := 0; i < uploads; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			imageIDs[i], errs[i] = imageStore.Save(uuid.New().String(), ".png", "admin1", bytes.NewReader(content))
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+
+	first, err := imageStore.Find(imageIDs[0])
+	require.NoError(t, err)
+	require.Len(t, first.Thumbnails, 2)
+
+	for _, thumbnail := range first.Thumbnails {
+		data, err := ioutil.ReadFile(thumbnail.Path)
+		require.NoError(t, err)
+		require.Equal(t, thumbnail.FileSize, int64(len(data)))
+
+		checksum := sha256.Sum256(data)
+		require.Equal(t, thumbnail.Checksum, hex.EncodeToString(checksum[:]))
+	}
+
+	// no temporary file is left behind
+	files, err := ioutil.ReadDir(imageFolder)
+	require.NoError(t, err)
+	require.Len(t, files, 3+uploads)
+}
+
+func TestDiskImageStoreMigratesLegacyFiles(t *testing.T) {
+	t.Parallel()
+
+	imageStore, imageFolder := newTestImageStore(t)
+
+	imageID, err := imageStore.Save(uuid.New().String(), ".png", "admin1", bytes.NewReader(newTestPNG(t, 40, 30)))
+	require.NoError(t, err)
+
+	info, err := imageStore.Find(imageID)
+	require.NoError(t, err)
+
+	// images used to be stored in a file named by their ID
+	legacyPath := filepath.Join(imageFolder, imageID+".png")
+	require.NoError(t, os.Rename(info.Path, legacyPath))
+
+	reloaded, err := service.NewDiskImageStore(imageFolder)
+	require.NoError(t, err)
+
+	migrated, err := reloaded.Find(imageID)
+	require.NoError(t, err)
+	require.Equal(t, info.Path, migrated.Path)
+	require.FileExists(t, migrated.Path)
+	require.NoFileExists(t, legacyPath)
+}
+
+func TestDiskImageStoreSaveValidatesContent(t *testing.T) {
+	t.Parallel()
+
+	jpg, err := ioutil.ReadFile("../tmp/laptop.jpg")
+	require.NoError(t, err)
+
+	pngBuffer := bytes.Buffer{}
+	err = png.Encode(&pngBuffer, image.NewRGBA(image.Rect(0, 0, 40, 30)))
+	require.NoError(t, err)
+
+	// headers declaring 60000x60000 pixels, that would take gigabytes to decode
+	jpgBomb, err := ioutil.ReadFile("../tmp/laptop_bomb.jpg")
+	require.NoError(t, err)
+	pngBomb, err := ioutil.ReadFile("../tmp/laptop_bomb.png")
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name      string
+		imageType string
+		content   []byte
+		maxPixels int64
+		valid     bool
+	}{
+		{name: "jpeg", imageType: ".jpg", content: jpg, valid: true},
+		{name: "jpeg_upper_case_type", imageType: ".JPEG", content: jpg, valid: true},
+		{name: "png", imageType: ".png", content: pngBuffer.Bytes(), valid: true},
+		{name: "type_mismatch", imageType: ".png", content: jpg},
+		{name: "not_an_image", imageType: ".jpg", content: []byte("not an image")},
+		{name: "truncated_image", imageType: ".jpg", content: jpg[:len(jpg)/2]},
+		{name: "type_not_accepted", imageType: ".exe", content: jpg},
+		{name: "path_in_type", imageType: "/../../laptop.jpg", content: jpg},
+		{name: "jpeg_too_large", imageType: ".jpg", content: jpgBomb},
+		{name: "png_too_large", imageType: ".png", content: pngBomb},
+		{name: "png_at_max_pixels", imageType: ".png", content: pngBuffer.Bytes(), maxPixels: 40 * 30, valid: true},
+		{name: "png_over_max_pixels", imageType: ".png", content: pngBuffer.Bytes(), maxPixels: 40*30 - 1},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			imageStore, imageFolder := newTestImageStore(t)
+			if tc.maxPixels > 0 {
+				imageStore.MaxImagePixels = tc.maxPixels
+			}
+
+			imageID, err := imageStore.Save(uuid.New().String(), tc.imageType, "admin1", bytes.NewReader(tc.content))
+			if !tc.valid {
+				require.True(t, errors.Is(err, service.ErrInvalidImage))
+
+				files, err := ioutil.ReadDir(imageFolder)
+				require.NoError(t, err)
+				require.Empty(t, files)
+				return
+			}
+
+			require.NoError(t, err)
+
+			info, err := imageStore.Find(imageID)
+			require.NoError(t, err)
+			require.NotZero(t, info.Width)
+			require.NotZero(t, info.Height)
+		})
+	}
+}
+
+func TestDiskImageStoreThumbnails(t *testing.T) {
+	t.Parallel()
+
+	imageStore, imageFolder := newTestImageStore(t)
+
+	imageID, err := imageStore.Save(uuid.New().String(), ".png", "admin1", bytes.NewReader(newTestPNG(t, 600, 300)))
+	require.NoError(t, err)
+
+	info, err := imageStore.Find(imageID)
+	require.NoError(t, err)
+	require.Len(t, info.Thumbnails, 2)
+
+	expected := []struct{ size, width, height int }{{128, 128, 64}, {512, 512, 256}}
+	for i, thumbnail := range info.Thumbnails {
+		require.Equal(t, expected[i].size, thumbnail.Size)
+		require.Equal(t, expected[i].width, thumbnail.Width)
+		require.Equal(t, expected[i].height, thumbnail.Height)
+
+		reader, err := imageStore.OpenThumbnail(imageID, thumbnail.Size)
+		require.NoError(t, err)
+
+		config, format, err := image.DecodeConfig(reader)
+		require.NoError(t, reader.Close())
+		require.NoError(t, err)
+		require.Equal(t, "png", format)
+		require.Equal(t, expected[i].width, config.Width)
+		require.Equal(t, expected[i].height, config.Height)
+	}
+
+	_, err = imageStore.OpenThumbnail(imageID, 256)
+	require.Equal(t, service.ErrNotFound, err)
+
+	reloaded, err := service.NewDiskImageStore(imageFolder)
+	require.NoError(t, err)
+
+	reloadedInfo, err := reloaded.Find(imageID)
+	require.NoError(t, err)
+	require.Equal(t, info.Thumbnails, reloadedInfo.Thumbnails)
+
+	report, err := reloaded.Reconcile()
+	require.NoError(t, err)
+	require.Empty(t, report.FilesWithoutMetadata)
+
+	smallID, err := reloaded.Save(uuid.New().String(), ".png", "admin1", bytes.NewReader(newTestPNG(t, 100, 200)))
+	require.NoError(t, err)
+
+	smallInfo, err := reloaded.Find(smallID)
+	require.NoError(t, err)
+	require.Len(t, smallInfo.Thumbnails, 1)
+	require.Equal(t, 64, smallInfo.Thumbnails[0].Width)
+	require.Equal(t, 128, smallInfo.Thumbnails[0].Height)
+
+	require.NoError(t, reloaded.Delete(imageID))
+	require.NoError(t, reloaded.Delete(smallID))
+
+	files, err := ioutil.ReadDir(imageFolder)
+	require.NoError(t, err)
+	require.Empty(t, files)
+}
+
+// newTestPNG returns an encoded PNG image of the given size
+func newTestPNG(t *testing.T, width int, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i)
+	}
+
+	buffer := bytes.Buffer{}
+	require.NoError(t, png.Encode(&buffer, img))
+	return buffer.Bytes()
+}
+
+func TestDiskImageStoreStripMetadata(t *testing.T) {
+	t.Parallel()
+
+	// metadata found in the fixtures: EXIF, XMP, JPEG comments and PNG text chunks,
+	// also between the scans of a progressive JPEG and in a thumbnail after its end of image
+	metadata := [][]byte{[]byte("Exif"), []byte("eXIf"), []byte("PhoneMaker"), []byte("SN:A1B2C3"), []byte("GPSLatitude")}
+
+	for _, imagePath := range []string{"../tmp/laptop_exif.jpg", "../tmp/laptop_exif_scans.jpg", "../tmp/laptop_exif.png"} {
+		content, err := ioutil.ReadFile(imagePath)
+		require.NoError(t, err)
+		require.True(t, bytes.Contains(content, []byte("SN:A1B2C3")))
+
+		original, _, err := image.DecodeConfig(bytes.NewReader(content))
+		require.NoError(t, err)
+
+		for _, strip := range []bool{false, true} {
+			imageStore, _ := newTestImageStore(t)
+			imageStore.StripMetadata = strip
+
+			imageID, err := imageStore.Save(uuid.New().String(), filepath.Ext(imagePath), "admin1", bytes.NewReader(content))
+			require.NoError(t, err)
+
+			info, err := imageStore.Find(imageID)
+			require.NoError(t, err)
+
+			stored, err := ioutil.ReadFile(info.Path)
+			require.NoError(t, err)
+			require.Equal(t, int64(len(stored)), info.Size)
+
+			checksum := sha256.Sum256(stored)
+			require.Equal(t, hex.EncodeToString(checksum[:]), info.Checksum)
+
+			if !strip {
+				require.True(t, bytes.Equal(content, stored))
+				continue
+			}
+
+			require.Less(t, len(stored), len(content))
+			for _, data := range metadata {
+				require.False(t, bytes.Contains(stored, data), "%s found in %s", data, imagePath)
+			}
+			if filepath.Ext(imagePath) == ".jpg" {
+				require.True(t, bytes.HasSuffix(stored, []byte{0xFF, 0xD9}))
+				require.Equal(t, 1, bytes.Count(stored, []byte{0xFF, 0xD9}))
+			}
+
+			img, _, err := image.Decode(bytes.NewReader(stored))
+			require.NoError(t, err)
+			require.Equal(t, original.Width, img.Bounds().Dx())
+			require.Equal(t, original.Height, img.Bounds().Dy())
+		}
+	}
+}
+
+func TestDiskImageStoreStripMetadataKeepsImage(t *testing.T) {
+	t.Parallel()
+
+	content := newTestPNG(t, 60, 30)
+	end := len(content) - 12
+	private := pngTestChunk("prVt", []byte("SN:A1B2C3"))
+
+	testCases := []struct {
+		name      string
+		imageType string
+		content   []byte
+		// kept and removed are data expected in the stored image and not in it
+		kept    []byte
+		removed []byte
+	}{
+		{
+			name:      "jpeg_adobe",
+			imageType: ".jpg",
+			content:   newTestAdobeJPEG(t, 60, 30),
+			kept:      []byte("Adobe"),
+		}, {
+			name:      "png_private_chunk",
+			imageType: ".png",
+			content:   append(append(append([]byte{}, content[:end]...), private...), content[end:]...),
+			kept:      []byte("IDAT"),
+			removed:   private,
+		}, {
+			name:      "png_trailing_data",
+			imageType: ".png",
+			content:   append(append(append([]byte{}, content...), private...), "trailing"...),
+			kept:      content,
+			removed:   []byte("trailing"),
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			imageStore, _ := newTestImageStore(t)
+			imageStore.StripMetadata = true
+
+			imageID, err := imageStore.Save(uuid.New().String(), tc.imageType, "admin1", bytes.NewReader(tc.content))
+			require.NoError(t, err)
+
+			info, err := imageStore.Find(imageID)
+			require.NoError(t, err)
+
+			stored, err := ioutil.ReadFile(info.Path)
+			require.NoError(t, err)
+			require.True(t, bytes.Contains(stored, tc.kept))
+			if tc.removed != nil {
+				require.False(t, bytes.Contains(stored, tc.removed))
+			}
+
+			// the pixels are decoded the same
+			original, _, err := image.Decode(bytes.NewReader(tc.content))
+			require.NoError(t, err)
+			img, _, err := image.Decode(bytes.NewReader(stored))
+			require.NoError(t, err)
+			require.Equal(t, original, img)
+		})
+	}
+}
+
+// newTestAdobeJPEG returns an encoded JPEG image of the given size with an Adobe segment
+// telling decoders its components are RGB rather than YCbCr
+func newTestAdobeJPEG(t *testing.T, width int, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i)
+	}
+
+	buffer := bytes.Buffer{}
+	require.NoError(t, jpeg.Encode(&buffer, img, nil))
+	encoded := buffer.Bytes()
+
+	// version, flags and transform 0, for RGB
+	adobe := append([]byte{0xFF, 0xEE, 0x00, 0x0E}, "Adobe\x00\x64\x00\x00\x00\x00\x00"...)
+
+	return append(append(append([]byte{}, encoded[:2]...), adobe...), encoded[2:]...)
+}
+
+// pngTestChunk returns an encoded PNG chunk
+func pngTestChunk(chunkType string, data []byte) []byte {
+	chunk := make([]byte, 8, 12+len(data))
+	binary.BigEndian.PutUint32(chunk, uint32(len(data)))
+	copy(chunk[4:], chunkType)
+	chunk = append(chunk, data...)
+
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.ChecksumIEEE(chunk[4:]))
+	return append(chunk, crc...)
+}
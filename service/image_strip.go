@@ -0,0 +1,271 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// JPEG markers
+const (
+	jpegFirstRestart = 0xD0
+	jpegLastRestart  = 0xD7
+	jpegStartOfImage = 0xD8
+	jpegEndOfImage   = 0xD9
+	jpegStartOfScan  = 0xDA
+	jpegFirstApp     = 0xE0
+	jpegICCApp       = 0xE2
+	jpegAdobeApp     = 0xEE
+	jpegLastApp      = 0xEF
+	jpegComment      = 0xFE
+)
+
+var (
+	jpegICCProfile = []byte("ICC_PROFILE\x00")
+	jpegAdobe      = []byte("Adobe")
+	pngSignature   = []byte("\x89PNG\r\n\x1a\n")
+)
+
+// pngEnd is the type of the last PNG chunk
+const pngEnd = "IEND"
+
+// pngImageChunks are the PNG chunks kept when stripping metadata: the critical chunks,
+// and the ones that change how the pixels are rendered
+var pngImageChunks = map[string]bool{
+	"IHDR": true,
+	"PLTE": true,
+	"IDAT": true,
+	"IEND": true,
+	"tRNS": true,
+	"gAMA": true,
+	"cHRM": true,
+	"sRGB": true,
+	"iCCP": true,
+	"sBIT": true,
+	"pHYs": true,
+}
+
+// stripImageMetadata rewrites the image file at path without the metadata of its format:
+// the APPn segments and comments of JPEG images, except the JFIF header, ICC color profile and Adobe color transform,
+// and the chunks of PNG images that don't change how the pixels are rendered. Other image types are left as they are.
+func stripImageMetadata(path string, imageType string) error {
+	var strip func(io.Writer, *bufio.Reader) error
+
+	switch imageType {
+	case ".jpg", ".jpeg":
+		strip = stripJPEGMetadata
+	case ".png":
+		strip = stripPNGMetadata
+	default:
+		return nil
+	}
+
+	src, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("cannot open image file: %v", err)
+	}
+	defer src.Close()
+
+	strippedPath := path + tmpExt
+	dst, err := os.Create(strippedPath)
+	if err != nil {
+		return fmt.Errorf("cannot create image file: %v", err)
+	}
+
+	writer := bufio.NewWriter(dst)
+	err = strip(writer, bufio.NewReader(src))
+	if err == nil {
+		err = writer.Flush()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(strippedPath, path)
+	}
+	if err != nil {
+		os.Remove(strippedPath)
+		return err
+	}
+
+	return nil
+}
+
+// stripJPEGMetadata copies the JPEG segments and the data of every scan, leaving out the metadata segments
+// wherever they are, and stops at the end of image, leaving out any data after it
+func stripJPEGMetadata(dst io.Writer, src *bufio.Reader) error {
+	header := make([]byte, 2)
+	_, err := io.ReadFull(src, header)
+	if err != nil || header[0] != 0xFF || header[1] != jpegStartOfImage {
+		return fmt.Errorf("%w: missing JPEG start of image", ErrInvalidImage)
+	}
+
+	_, err = dst.Write(header)
+	if err != nil {
+		return fmt.Errorf("cannot write image file: %v", err)
+	}
+
+	marker, err := readJPEGMarker(src)
+	for {
+		if err != nil {
+			return err
+		}
+
+		if marker == jpegEndOfImage {
+			_, err = dst.Write([]byte{0xFF, marker})
+			if err != nil {
+				return fmt.Errorf("cannot write image file: %v", err)
+			}
+			return nil
+		}
+
+		length := make([]byte, 2)
+		_, err = io.ReadFull(src, length)
+		if err != nil || binary.BigEndian.Uint16(length) < 2 {
+			return fmt.Errorf("%w: truncated JPEG segment", ErrInvalidImage)
+		}
+
+		data := make([]byte, binary.BigEndian.Uint16(length)-2)
+		_, err = io.ReadFull(src, data)
+		if err != nil {
+			return fmt.Errorf("%w: truncated JPEG segment", ErrInvalidImage)
+		}
+
+		if !isJPEGMetadata(marker, data) {
+			_, err = dst.Write(append([]byte{0xFF, marker}, append(length, data...)...))
+			if err != nil {
+				return fmt.Errorf("cannot write image file: %v", err)
+			}
+		}
+
+		if marker == jpegStartOfScan {
+			marker, err = copyJPEGScan(dst, src)
+		} else {
+			marker, err = readJPEGMarker(src)
+		}
+	}
+}
+
+// copyJPEGScan copies the entropy-coded data of a scan, with its stuffed bytes and restart markers,
+// and returns the marker of the segment following it
+func copyJPEGScan(dst io.Writer, src *bufio.Reader) (byte, error) {
+	for {
+		data, err := src.ReadSlice(0xFF)
+		if err == nil {
+			data = data[:len(data)-1]
+		} else if err != bufio.ErrBufferFull {
+			return 0, fmt.Errorf("%w: truncated JPEG scan", ErrInvalidImage)
+		}
+
+		_, writeErr := dst.Write(data)
+		if writeErr != nil {
+			return 0, fmt.Errorf("cannot write image file: %v", writeErr)
+		}
+		if err != nil {
+			continue
+		}
+
+		marker, err := src.ReadByte()
+		for err == nil && marker == 0xFF {
+			marker, err = src.ReadByte()
+		}
+		if err != nil {
+			return 0, fmt.Errorf("%w: truncated JPEG scan", ErrInvalidImage)
+		}
+
+		if marker != 0x00 && (marker < jpegFirstRestart || marker > jpegLastRestart) {
+			return marker, nil
+		}
+
+		_, err = dst.Write([]byte{0xFF, marker})
+		if err != nil {
+			return 0, fmt.Errorf("cannot write image file: %v", err)
+		}
+	}
+}
+
+// readJPEGMarker reads a segment marker, skipping fill bytes
+func readJPEGMarker(src *bufio.Reader) (byte, error) {
+	b, err := src.ReadByte()
+	if err != nil || b != 0xFF {
+		return 0, fmt.Errorf("%w: missing JPEG marker", ErrInvalidImage)
+	}
+
+	for b == 0xFF {
+		b, err = src.ReadByte()
+		if err != nil {
+			return 0, fmt.Errorf("%w: missing JPEG marker", ErrInvalidImage)
+		}
+	}
+
+	return b, nil
+}
+
+func isJPEGMetadata(marker byte, data []byte) bool {
+	if marker == jpegComment {
+		return true
+	}
+	if marker == jpegICCApp && bytes.HasPrefix(data, jpegICCProfile) {
+		return false
+	}
+	// decoders read the color transform of Adobe and CMYK images from the Adobe segment
+	if marker == jpegAdobeApp && bytes.HasPrefix(data, jpegAdobe) {
+		return false
+	}
+	// APP0 is the JFIF header
+	return marker > jpegFirstApp && marker <= jpegLastApp
+}
+
+// stripPNGMetadata copies the PNG chunks of the image, leaving out the metadata ones,
+// and stops at the end of image, leaving out any data after it
+func stripPNGMetadata(dst io.Writer, src *bufio.Reader) error {
+	signature := make([]byte, len(pngSignature))
+	_, err := io.ReadFull(src, signature)
+	if err != nil || !bytes.Equal(signature, pngSignature) {
+		return fmt.Errorf("%w: missing PNG signature", ErrInvalidImage)
+	}
+
+	_, err = dst.Write(signature)
+	if err != nil {
+		return fmt.Errorf("cannot write image file: %v", err)
+	}
+
+	for {
+		// length and type
+		header := make([]byte, 8)
+		_, err := io.ReadFull(src, header)
+		if err == io.EOF {
+			return fmt.Errorf("%w: missing PNG end", ErrInvalidImage)
+		}
+		if err != nil {
+			return fmt.Errorf("%w: truncated PNG chunk", ErrInvalidImage)
+		}
+
+		// data and CRC
+		size := int64(binary.BigEndian.Uint32(header[:4])) + 4
+		chunkType := string(header[4:])
+
+		if pngImageChunks[chunkType] {
+			_, err = dst.Write(header)
+			if err == nil {
+				_, err = io.CopyN(dst, src, size)
+			}
+		} else {
+			_, err = io.CopyN(ioutil.Discard, src, size)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return fmt.Errorf("%w: truncated PNG chunk", ErrInvalidImage)
+		}
+		if err != nil {
+			return fmt.Errorf("cannot write image file: %v", err)
+		}
+
+		if chunkType == pngEnd {
+			return nil
+		}
+	}
+}
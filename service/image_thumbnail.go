@@ -0,0 +1,181 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+)
+
+// ThumbnailInfo contains information about a thumbnail of a laptop image
+type ThumbnailInfo struct {
+	// Size is the longest side the thumbnail was scaled down to
+	Size     int    `json:"size"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	Path     string `json:"-"`
+	FileSize int64  `json:"file_size"`
+	Checksum string `json:"checksum"`
+}
+
+// Default thumbnail sizes are 128 and 512 pixels
+var defaultThumbnailSizes = []int{128, 512}
+
+// writeThumbnails writes a scaled down copy of img, to a temporary file next to the thumbnail path,
+// for every thumbnail size smaller than the image. On error, the thumbnails written so far are removed.
+func (store *DiskImageStore) writeThumbnails(info *ImageInfo, img image.Image, format string) ([]ThumbnailInfo, error) {
+	tmpSuffix := thumbnailTmpSuffix(info)
+
+	var thumbnails []ThumbnailInfo
+
+	for _, size := range store.ThumbnailSizes {
+		width, height, ok := thumbnailDimensions(img.Bounds(), size)
+		if !ok {
+			continue
+		}
+
+		thumbnail := ThumbnailInfo{
+			Size:   size,
+			Width:  width,
+			Height: height,
+			Path:   store.thumbnailPath(info.Checksum, size, info.Type),
+		}
+
+		err := writeThumbnail(&thumbnail, scaleDown(img, width, height), format, tmpSuffix)
+		if err != nil {
+			removeThumbnails(thumbnails, tmpSuffix)
+			return nil, err
+		}
+
+		thumbnails = append(thumbnails, thumbnail)
+	}
+
+	return thumbnails, nil
+}
+
+// thumbnailTmpSuffix returns the suffix of the temporary thumbnail files of an image.
+// Thumbnails are stored by content, so the suffix includes the image ID to keep concurrent uploads
+// of the same content from writing to the same files.
+func thumbnailTmpSuffix(info *ImageInfo) string {
+	return "." + info.ID + tmpExt
+}
+
+// writeThumbnail encodes img in format to the temporary thumbnail file, the thumbnail path with tmpSuffix,
+// and records its file size and checksum
+func writeThumbnail(thumbnail *ThumbnailInfo, img image.Image, format string, tmpSuffix string) error {
+	file, err := os.Create(thumbnail.Path + tmpSuffix)
+	if err != nil {
+		return fmt.Errorf("cannot create thumbnail file: %v", err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	counter := &countingWriter{}
+	writer := io.MultiWriter(file, hash, counter)
+
+	switch format {
+	case "jpeg":
+		err = jpeg.Encode(writer, img, &jpeg.Options{Quality: 85})
+	case "png":
+		err = png.Encode(writer, img)
+	case "gif":
+		err = gif.Encode(writer, img, nil)
+	default:
+		err = fmt.Errorf("unknown image format %v", format)
+	}
+	if err != nil {
+		os.Remove(thumbnail.Path + tmpSuffix)
+		return fmt.Errorf("cannot encode thumbnail: %v", err)
+	}
+
+	thumbnail.FileSize = counter.n
+	thumbnail.Checksum = hex.EncodeToString(hash.Sum(nil))
+
+	return nil
+}
+
+// removeThumbnails removes the thumbnail files, with suffix appended to their paths
+func removeThumbnails(thumbnails []ThumbnailInfo, suffix string) {
+	for _, thumbnail := range thumbnails {
+		os.Remove(thumbnail.Path + suffix)
+	}
+}
+
+// thumbnailDimensions returns the dimensions of the thumbnail of an image with the given bounds,
+// whose longest side is size. It returns false if the image isn't larger than size.
+func thumbnailDimensions(bounds image.Rectangle, size int) (int, int, bool) {
+	width, height := bounds.Dx(), bounds.Dy()
+
+	if width <= size && height <= size {
+		return 0, 0, false
+	}
+
+	if width >= height {
+		return size, maxInt(1, height*size/width), true
+	}
+	return maxInt(1, width*size/height), size, true
+}
+
+// scaleDown resizes img to width x height by averaging the source pixels covered by each
+// destination pixel, which keeps thumbnails smooth without an external imaging library
+func scaleDown(img image.Image, width int, height int) *image.RGBA {
+	bounds := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		y0 := y * srcHeight / height
+		y1 := maxInt(y0+1, (y+1)*srcHeight/height)
+
+		for x := 0; x < width; x++ {
+			x0 := x * srcWidth / width
+			x1 := maxInt(x0+1, (x+1)*srcWidth/width)
+
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				offset := src.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					for c := 0; c < 4; c++ {
+						sum[c] += int(src.Pix[offset+c])
+					}
+					offset += 4
+				}
+			}
+
+			count := (x1 - x0) * (y1 - y0)
+			offset := dst.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				dst.Pix[offset+c] = uint8(sum[c] / count)
+			}
+		}
+	}
+
+	return dst
+}
+
+// maxInt returns the larger of a and b
+func maxInt(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// countingWriter counts the bytes written to it
+type countingWriter struct {
+	n int64
+}
+
+func (writer *countingWriter) Write(p []byte) (int, error) {
+	writer.n += int64(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,226 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// partExt is the extension of the partial files of upload sessions
+const partExt = ".part"
+
+// ErrUploadOffset for chunks that are not written at the received offset of their upload session
+var ErrUploadOffset = errors.New("chunk offset doesn't match the received bytes")
+
+// ErrUploadIncomplete for upload sessions that are completed before receiving all bytes
+var ErrUploadIncomplete = errors.New("upload is incomplete")
+
+// UploadSession contains the state of a resumable image upload
+type UploadSession struct {
+	ID        string
+	LaptopID  string
+	Type      string
+	Uploader  string
+	Size      int64
+	Received  int64
+	ExpiresAt time.Time
+}
+
+// uploadSession is an upload session and the partial file it's written to
+type uploadSession struct {
+	// mutex serializes writes to the partial file
+	mutex sync.Mutex
+	UploadSession
+	path string
+}
+
+// StartUpload starts a resumable upload, the image is written to a partial file until it's complete
+func (store *DiskImageStore) StartUpload(laptopID string, imageType string, uploader string, size int64) (*UploadSession, error) {
+	imageType, err := normalizeImageType(imageType)
+	if err != nil {
+		return nil, err
+	}
+
+	sessionID, err := uuid.NewRandom()
+	if err != nil {
+		return nil, fmt.Errorf("cannot create upload session id: %v", err)
+	}
+
+	path := fmt.Sprintf("%s/%s%s", store.imageFolder, sessionID, partExt)
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create partial image file: %v", err)
+	}
+	file.Close()
+
+	session := &uploadSession{
+		UploadSession: UploadSession{
+			ID:        sessionID.String(),
+			LaptopID:  laptopID,
+			Type:      imageType,
+			Uploader:  uploader,
+			Size:      size,
+			ExpiresAt: time.Now().Add(store.UploadExpiry),
+		},
+		path: path,
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.removeExpiredUploads()
+	store.uploads[session.ID] = session
+
+	other := session.UploadSession
+	return &other, nil
+}
+
+// FindUpload returns the state of the upload session with the Id of sessionID
+func (store *DiskImageStore) FindUpload(sessionID string) (*UploadSession, error) {
+	session, err := store.findUpload(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	other := session.UploadSession
+	return &other, nil
+}
+
+// WriteUpload appends chunk to the partial file of the upload session
+func (store *DiskImageStore) WriteUpload(sessionID string, offset int64, chunk []byte) (*UploadSession, error) {
+	session, err := store.findUpload(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	if offset != session.Received {
+		return nil, ErrUploadOffset
+	}
+
+	if session.Received+int64(len(chunk)) > session.Size {
+		return nil, fmt.Errorf("chunk exceeds the upload size of %d bytes", session.Size)
+	}
+
+	file, err := os.OpenFile(session.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open partial image file: %v", err)
+	}
+	defer file.Close()
+
+	n, err := file.Write(chunk)
+	session.Received += int64(n)
+
+	// ExpiresAt is also read by removeExpiredUploads, which only holds the store mutex
+	store.mutex.Lock()
+	session.ExpiresAt = time.Now().Add(store.UploadExpiry)
+	store.mutex.Unlock()
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot write partial image file: %v", err)
+	}
+
+	other := session.UploadSession
+	return &other, nil
+}
+
+// CompleteUpload moves the partial file of a fully received upload session into place as a new image
+func (store *DiskImageStore) CompleteUpload(sessionID string) (*ImageInfo, error) {
+	session, err := store.findUpload(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	if session.Received != session.Size {
+		return nil, ErrUploadIncomplete
+	}
+
+	checksum, err := fileChecksum(session.path)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &ImageInfo{
+		ID:       session.ID,
+		LaptopID: session.LaptopID,
+		Type:     session.Type,
+		Size:     session.Size,
+		Checksum: checksum,
+		Uploader: session.Uploader,
+	}
+
+	err = store.add(session.path, info)
+
+	// the partial file is gone whether the image was added or not
+	store.mutex.Lock()
+	delete(store.uploads, sessionID)
+	store.mutex.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	other := *info
+	return &other, nil
+}
+
+// findUpload returns the upload session with the Id of sessionID unless it has expired
+func (store *DiskImageStore) findUpload(sessionID string) (*uploadSession, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.removeExpiredUploads()
+
+	session := store.uploads[sessionID]
+	if session == nil {
+		return nil, ErrNotFound
+	}
+
+	return session, nil
+}
+
+// removeExpiredUploads drops the expired upload sessions and their partial files,
+// the store mutex must be held
+func (store *DiskImageStore) removeExpiredUploads() {
+	now := time.Now()
+
+	for sessionID, session := range store.uploads {
+		if now.After(session.ExpiresAt) {
+			os.Remove(session.path)
+			delete(store.uploads, sessionID)
+		}
+	}
+}
+
+// fileChecksum returns the hex encoded SHA-256 of the file at path
+func fileChecksum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("cannot open image file: %v", err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return "", fmt.Errorf("cannot read image file: %v", err)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -18,6 +18,8 @@ type UserClaims struct {
 	jwt.StandardClaims
 	Username string `json:"username"`
 	Role     string `json:"role"`
+	// CreatedAt is the Unix time the user account was created at, 0 if unknown
+	CreatedAt int64 `json:"created_at,omitempty"`
 }
 
 // NewJWTManager returns a new JWT manager
@@ -34,6 +36,9 @@ func (manager *JWTManager) Generate(user *User) (string, error) {
 		Username: user.Username,
 		Role:     user.Role,
 	}
+	if !user.CreatedAt.IsZero() {
+		claims.CreatedAt = user.CreatedAt.Unix()
+	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return token.SignedString([]byte(manager.secretKey))
@@ -2,20 +2,33 @@ package service_test
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"grpc_youtube_tutorial/memory"
 	"grpc_youtube_tutorial/pb"
 	"grpc_youtube_tutorial/sample"
 	"grpc_youtube_tutorial/service"
+	"image/png"
 	"io"
+	"io/ioutil"
+	"math"
 	"net"
 	"os"
 	"path/filepath"
 	"strconv"
 	"testing"
+	"time"
 
+	"github.com/golang/protobuf/proto"
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 func TestClientRating(t *testing.T) {
@@ -28,40 +41,667 @@ func TestClientRating(t *testing.T) {
 	err := laptopStore.Save(laptop)
 	require.NoError(t, err)
 
+	laptopServer := service.NewLaptopServer(laptopStore, nil, ratingStore)
+	serverAddr, jwtManager := serveTestAuthLaptopServer(t, laptopServer)
+	laptopClient := newTestLaptopClient(t, serverAddr)
+
+	// re-rating replaces the score of the user instead of adding one
+	ratings := []struct {
+		username     string
+		scores       []float64
+		ratedCount   []uint32
+		averageScore []float64
+		updated      []bool
+	}{
+		{
+			username:     "user1",
+			scores:       []float64{8, 7.5, 10},
+			ratedCount:   []uint32{1, 1, 1},
+			averageScore: []float64{8, 7.5, 10},
+			updated:      []bool{false, true, true},
+		},
+		{
+			username:     "user2",
+			scores:       []float64{6, 9},
+			ratedCount:   []uint32{2, 2},
+			averageScore: []float64{8, 9.5},
+			updated:      []bool{false, true},
+		},
+	}
+
+	for _, rating := range ratings {
+		ctx := newTestUserContext(t, jwtManager, rating.username, "user")
+		stream, err := laptopClient.RateLaptop(ctx)
+		require.NoError(t, err)
+
+		n := len(rating.scores)
+		for i := 0; i < n; i++ {
+			req := &pb.RateLaptopRequest{
+				LaptopId: laptop.GetId(),
+				Score:    rating.scores[i],
+			}
+			err := stream.Send(req)
+			require.NoError(t, err)
+		}
+
+		err = stream.CloseSend()
+		require.NoError(t, err)
+
+		for idx := 0; ; idx++ {
+			res, err := stream.Recv()
+			if err == io.EOF {
+				require.Equal(t, n, idx)
+				break
+			}
+			require.NoError(t, err)
+			require.Equal(t, laptop.GetId(), res.GetLaptopId())
+			require.Equal(t, rating.ratedCount[idx], res.GetRatedCount())
+			require.Equal(t, rating.averageScore[idx], res.GetAverageScore())
+			require.Equal(t, rating.updated[idx], res.GetUpdated())
+		}
+	}
+
+	// ratings are kept per user, so they need an authenticated user
+	stream, err := newTestLaptopClient(t, startTestLaptopServer(t, laptopStore, nil, ratingStore)).RateLaptop(context.Background())
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestClientGetRating(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	ratingStore := service.NewInMemoryRatingStore()
+
+	laptop := sample.NewLaptop()
+	err := laptopStore.Save(laptop)
+	require.NoError(t, err)
+
 	serverAddr := startTestLaptopServer(t, laptopStore, nil, ratingStore)
 	laptopClient := newTestLaptopClient(t, serverAddr)
 
-	stream, err := laptopClient.RateLaptop(context.Background())
+	req := &pb.GetRatingRequest{LaptopId: laptop.GetId()}
+	res, err := laptopClient.GetRating(context.Background(), req)
 	require.NoError(t, err)
+	require.Equal(t, laptop.GetId(), res.GetLaptopId())
+	require.Zero(t, res.GetRatedCount())
+	require.Empty(t, res.GetHistogram())
 
-	scores := []float64{8, 7.5, 10}
-	expectedAverageScore := []float64{8, 7.75, 8.5}
+	for i, score := range []float64{6, 8, 8, 10} {
+		_, _, err := ratingStore.Add(laptop.GetId(), fmt.Sprintf("user%d", i), score)
+		require.NoError(t, err)
+	}
 
-	n := len(scores)
-	for i := 0; i < n; i++ {
-		req := &pb.RateLaptopRequest{
-			LaptopId: laptop.GetId(),
-			Score:    scores[i],
-		}
-		err := stream.Send(req)
+	res, err = laptopClient.GetRating(context.Background(), req)
+	require.NoError(t, err)
+	require.Equal(t, uint32(4), res.GetRatedCount())
+	require.Equal(t, 8.0, res.GetAverageScore())
+	require.Equal(t, 8.0, res.GetMedianScore())
+	require.InDelta(t, math.Sqrt(2), res.GetScoreStdDev(), 1e-9)
+	require.Len(t, res.GetHistogram(), 3)
+	for i, expected := range []struct {
+		score float64
+		count uint32
+	}{{6, 1}, {8, 2}, {10, 1}} {
+		require.Equal(t, expected.score, res.GetHistogram()[i].GetScore())
+		require.Equal(t, expected.count, res.GetHistogram()[i].GetCount())
+	}
+
+	_, err = laptopClient.GetRating(context.Background(), &pb.GetRatingRequest{LaptopId: "unknown"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestClientWatchRatings(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+
+	laptop1 := sample.NewLaptop()
+	laptop2 := sample.NewLaptop()
+	for _, laptop := range []*pb.Laptop{laptop1, laptop2} {
+		err := laptopStore.Save(laptop)
+		require.NoError(t, err)
+	}
+
+	laptopServer := service.NewLaptopServer(laptopStore, nil, service.NewInMemoryRatingStore())
+	serverAddr, jwtManager := serveTestAuthLaptopServer(t, laptopServer)
+	laptopClient := newTestLaptopClient(t, serverAddr)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	watchReq := &pb.WatchRatingsRequest{LaptopIds: []string{laptop1.GetId(), laptop2.GetId()}}
+	watchStream, err := laptopClient.WatchRatings(ctx, watchReq)
+	require.NoError(t, err)
+
+	// the current ratings come first
+	for _, laptop := range []*pb.Laptop{laptop1, laptop2} {
+		res, err := watchStream.Recv()
+		require.NoError(t, err)
+		require.Equal(t, laptop.GetId(), res.GetLaptopId())
+		require.Zero(t, res.GetRatedCount())
+	}
+
+	rateStream, err := laptopClient.RateLaptop(newTestUserContext(t, jwtManager, "user1", "user"))
+	require.NoError(t, err)
+
+	requests := []*pb.RateLaptopRequest{
+		{LaptopId: laptop1.GetId(), Score: 8},
+		{LaptopId: laptop2.GetId(), Score: 6},
+		{LaptopId: laptop1.GetId(), Score: 4},
+	}
+	for _, req := range requests {
+		require.NoError(t, rateStream.Send(req))
+
+		// wait for each rating to be added, so the updates aren't merged
+		_, err := rateStream.Recv()
+		require.NoError(t, err)
+
+		res, err := watchStream.Recv()
+		require.NoError(t, err)
+		require.Equal(t, req.GetLaptopId(), res.GetLaptopId())
+		require.Equal(t, uint32(1), res.GetRatedCount())
+		require.Equal(t, req.GetScore(), res.GetAverageScore())
+		require.InDelta(t, req.GetScore(), res.GetRecentAverageScore(), 1e-9)
+		require.InDelta(t, req.GetScore(), res.GetDecayedScore(), 1e-9)
+	}
+	require.NoError(t, rateStream.CloseSend())
+
+	cancel()
+	_, err = watchStream.Recv()
+	require.Equal(t, codes.Canceled, status.Code(err))
+
+	for _, laptopIDs := range [][]string{nil, {laptop1.GetId(), "unknown"}} {
+		watchStream, err := laptopClient.WatchRatings(context.Background(), &pb.WatchRatingsRequest{LaptopIds: laptopIDs})
+		require.NoError(t, err)
+
+		_, err = watchStream.Recv()
+		require.NotEqual(t, codes.OK, status.Code(err))
+	}
+}
+
+func TestClientTopRatedLaptops(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	ratingStore := service.NewInMemoryRatingStore()
+
+	laptops := make([]*pb.Laptop, 4)
+	for i := range laptops {
+		laptops[i] = sample.NewLaptop()
+		laptops[i].PriceUsd = 1000
+		laptops[i].Ram = &pb.Memory{Value: 16, Unit: pb.Memory_GIGABYTE}
+	}
+	laptops[2].PriceUsd = 4000
+
+	for i, laptop := range laptops {
+		err := laptopStore.Save(laptop)
 		require.NoError(t, err)
+
+		// the more ratings, the better the weighted score
+		for j := 0; j <= 2*i; j++ {
+			_, _, err := ratingStore.Add(laptop.GetId(), fmt.Sprintf("user%d", j), 9)
+			require.NoError(t, err)
+		}
+	}
+
+	// a rated laptop that's not in the laptop store anymore
+	_, _, err := ratingStore.Add("deleted", "user1", 10)
+	require.NoError(t, err)
+
+	serverAddr := startTestLaptopServer(t, laptopStore, nil, ratingStore)
+	laptopClient := newTestLaptopClient(t, serverAddr)
+
+	testCases := []struct {
+		name     string
+		req      *pb.TopRatedLaptopsRequest
+		expected []*pb.Laptop
+	}{
+		{
+			name:     "all",
+			req:      &pb.TopRatedLaptopsRequest{},
+			expected: []*pb.Laptop{laptops[3], laptops[2], laptops[1], laptops[0]},
+		},
+		{
+			name:     "limit",
+			req:      &pb.TopRatedLaptopsRequest{Limit: 2},
+			expected: []*pb.Laptop{laptops[3], laptops[2]},
+		},
+		{
+			name:     "filter",
+			req:      &pb.TopRatedLaptopsRequest{Limit: 2, Filter: &pb.Filter{MaxPriceUsd: 2000}},
+			expected: []*pb.Laptop{laptops[3], laptops[1]},
+		},
+	}
+
+	for _, tc := range testCases {
+		stream, err := laptopClient.TopRatedLaptops(context.Background(), tc.req)
+		require.NoError(t, err, tc.name)
+
+		for i, expected := range tc.expected {
+			res, err := stream.Recv()
+			require.NoError(t, err, tc.name)
+			require.Equal(t, expected.GetId(), res.GetLaptop().GetId(), tc.name)
+			require.Equal(t, uint32(i+1), res.GetRank(), tc.name)
+			require.Equal(t, 9.0, res.GetAverageScore(), tc.name)
+			require.Less(t, res.GetWeightedScore(), 9.0, tc.name)
+		}
+
+		_, err = stream.Recv()
+		require.Equal(t, io.EOF, err, tc.name)
 	}
+}
+
+func TestClientReviews(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
 
-	err = stream.CloseSend()
+	laptop := sample.NewLaptop()
+	err := laptopStore.Save(laptop)
 	require.NoError(t, err)
 
-	for idx := 0; ; idx++ {
+	laptopServer := service.NewLaptopServer(laptopStore, nil, service.NewInMemoryRatingStore())
+	laptopServer.ReviewValidators = append(laptopServer.ReviewValidators, service.ProfanityValidator("darn"))
+
+	serverAddr, jwtManager := serveTestAuthLaptopServer(t, laptopServer)
+	laptopClient := newTestLaptopClient(t, serverAddr)
+
+	reviewIDs := make(map[string]string)
+	for _, username := range []string{"user1", "user2", "user3"} {
+		stream, err := laptopClient.RateLaptop(newTestUserContext(t, jwtManager, username, "user"))
+		require.NoError(t, err)
+
+		requests := []*pb.RateLaptopRequest{
+			{LaptopId: laptop.GetId(), Score: 8, ReviewTitle: "Darn slow", ReviewBody: "rejected"},
+			{LaptopId: laptop.GetId(), Score: 10},
+			{LaptopId: laptop.GetId(), Score: 9, ReviewTitle: "Good", ReviewBody: "written by " + username},
+		}
+		for _, req := range requests {
+			require.NoError(t, stream.Send(req))
+		}
+		require.NoError(t, stream.CloseSend())
+
 		res, err := stream.Recv()
-		if err == io.EOF {
-			require.Equal(t, n, idx)
-			return
+		require.NoError(t, err)
+		require.Equal(t, codes.InvalidArgument, codes.Code(res.GetCode()))
+		require.Empty(t, res.GetReviewId())
+
+		res, err = stream.Recv()
+		require.NoError(t, err)
+		require.Equal(t, codes.OK, codes.Code(res.GetCode()))
+		require.Empty(t, res.GetReviewId())
+
+		// the score of a review pending moderation doesn't count yet
+		res, err = stream.Recv()
+		require.NoError(t, err)
+		require.Equal(t, codes.OK, codes.Code(res.GetCode()))
+		require.NotEmpty(t, res.GetReviewId())
+		require.Equal(t, pb.Review_PENDING, res.GetReviewStatus())
+		require.Equal(t, 10.0, res.GetAverageScore())
+		reviewIDs[username] = res.GetReviewId()
+
+		_, err = stream.Recv()
+		require.Equal(t, io.EOF, err)
+	}
+
+	user1 := newTestUserContext(t, jwtManager, "user1", "user")
+	user3 := newTestUserContext(t, jwtManager, "user3", "user")
+	admin := newTestUserContext(t, jwtManager, "admin1", "admin")
+
+	requireRating := func(count uint32, average float64) {
+		ratingRes, err := laptopClient.GetRating(context.Background(), &pb.GetRatingRequest{LaptopId: laptop.GetId()})
+		require.NoError(t, err)
+		require.Equal(t, count, ratingRes.GetRatedCount())
+		require.Equal(t, average, ratingRes.GetAverageScore())
+	}
+
+	rateTestLaptop := func(ctx context.Context, req *pb.RateLaptopRequest) *pb.RateLaptopResponse {
+		stream, err := laptopClient.RateLaptop(ctx)
+		require.NoError(t, err)
+		require.NoError(t, stream.Send(req))
+		require.NoError(t, stream.CloseSend())
+
+		res, err := stream.Recv()
+		require.NoError(t, err)
+		require.Equal(t, codes.OK, codes.Code(res.GetCode()))
+		return res
+	}
+
+	// reviews are only listed once approved
+	listReq := &pb.ListReviewsRequest{LaptopId: laptop.GetId(), PageSize: 2}
+	listRes, err := laptopClient.ListReviews(context.Background(), listReq)
+	require.NoError(t, err)
+	require.Empty(t, listRes.GetReviews())
+
+	_, err = laptopClient.ListPendingReviews(user1, &pb.ListPendingReviewsRequest{})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	pendingRes, err := laptopClient.ListPendingReviews(admin, &pb.ListPendingReviewsRequest{})
+	require.NoError(t, err)
+	require.Len(t, pendingRes.GetReviews(), 3)
+
+	for _, review := range pendingRes.GetReviews() {
+		require.Equal(t, pb.Review_PENDING, review.GetStatus())
+
+		moderateReq := &pb.ModerateReviewRequest{ReviewId: review.GetReviewId(), Approve: true}
+		moderateRes, err := laptopClient.ModerateReview(admin, moderateReq)
+		require.NoError(t, err)
+		require.Equal(t, pb.Review_APPROVED, moderateRes.GetReview().GetStatus())
+	}
+
+	_, err = laptopClient.ModerateReview(admin, &pb.ModerateReviewRequest{ReviewId: reviewIDs["user1"]})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	// the scores of approved reviews replace the older ratings of their authors
+	requireRating(3, 9)
+
+	listRes, err = laptopClient.ListReviews(context.Background(), listReq)
+	require.NoError(t, err)
+	require.Len(t, listRes.GetReviews(), 2)
+	require.Equal(t, reviewIDs["user3"], listRes.GetReviews()[0].GetReviewId())
+	require.Equal(t, "user3", listRes.GetReviews()[0].GetUsername())
+	require.Equal(t, "written by user3", listRes.GetReviews()[0].GetBody())
+	require.Equal(t, 9.0, listRes.GetReviews()[0].GetScore())
+	require.Equal(t, reviewIDs["user2"], listRes.GetReviews()[1].GetReviewId())
+	require.NotEmpty(t, listRes.GetNextPageToken())
+
+	listReq.PageToken = listRes.GetNextPageToken()
+	listRes, err = laptopClient.ListReviews(context.Background(), listReq)
+	require.NoError(t, err)
+	require.Len(t, listRes.GetReviews(), 1)
+	require.Equal(t, reviewIDs["user1"], listRes.GetReviews()[0].GetReviewId())
+	require.Empty(t, listRes.GetNextPageToken())
+
+	listReq.PageToken = "not a token"
+	_, err = laptopClient.ListReviews(context.Background(), listReq)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// users can only delete their own reviews, admins can delete any
+	_, err = laptopClient.DeleteReview(user1, &pb.DeleteReviewRequest{ReviewId: reviewIDs["user2"]})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = laptopClient.DeleteReview(user1, &pb.DeleteReviewRequest{ReviewId: reviewIDs["user1"]})
+	require.NoError(t, err)
+
+	_, err = laptopClient.DeleteReview(admin, &pb.DeleteReviewRequest{ReviewId: reviewIDs["user2"]})
+	require.NoError(t, err)
+
+	_, err = laptopClient.DeleteReview(admin, &pb.DeleteReviewRequest{ReviewId: reviewIDs["user2"]})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	listRes, err = laptopClient.ListReviews(context.Background(), &pb.ListReviewsRequest{LaptopId: laptop.GetId()})
+	require.NoError(t, err)
+	require.Len(t, listRes.GetReviews(), 1)
+	require.Equal(t, reviewIDs["user3"], listRes.GetReviews()[0].GetReviewId())
+
+	// the scores of deleted reviews no longer count
+	requireRating(1, 9)
+
+	// an edited review goes back to moderation, the score of the approved one no longer counts
+	res := rateTestLaptop(user3, &pb.RateLaptopRequest{LaptopId: laptop.GetId(), Score: 1, ReviewTitle: "Spam"})
+	require.Equal(t, reviewIDs["user3"], res.GetReviewId())
+	require.Equal(t, pb.Review_PENDING, res.GetReviewStatus())
+	requireRating(0, 0)
+
+	// a newer rating counts whatever the moderation of the review
+	rateTestLaptop(user3, &pb.RateLaptopRequest{LaptopId: laptop.GetId(), Score: 7})
+	requireRating(1, 7)
+
+	// its author sees why a review is rejected
+	moderateReq := &pb.ModerateReviewRequest{ReviewId: reviewIDs["user3"], Reason: "off topic"}
+	_, err = laptopClient.ModerateReview(admin, moderateReq)
+	require.NoError(t, err)
+
+	myRes, err := laptopClient.ListMyReviews(user3, &pb.ListMyReviewsRequest{})
+	require.NoError(t, err)
+	require.Len(t, myRes.GetReviews(), 1)
+	require.Equal(t, pb.Review_REJECTED, myRes.GetReviews()[0].GetStatus())
+	require.Equal(t, "off topic", myRes.GetReviews()[0].GetModerationReason())
+
+	listRes, err = laptopClient.ListReviews(context.Background(), &pb.ListReviewsRequest{LaptopId: laptop.GetId()})
+	require.NoError(t, err)
+	require.Empty(t, listRes.GetReviews())
+	requireRating(1, 7)
+
+	// approving a review doesn't replace a newer rating of its author
+	res = rateTestLaptop(user1, &pb.RateLaptopRequest{LaptopId: laptop.GetId(), Score: 2, ReviewTitle: "Meh"})
+	rateTestLaptop(user1, &pb.RateLaptopRequest{LaptopId: laptop.GetId(), Score: 5})
+	requireRating(2, 6)
+
+	_, err = laptopClient.ModerateReview(admin, &pb.ModerateReviewRequest{ReviewId: res.GetReviewId(), Approve: true})
+	require.NoError(t, err)
+	requireRating(2, 6)
+
+	// rejecting a review leaves the older rating of its author
+	res = rateTestLaptop(user1, &pb.RateLaptopRequest{LaptopId: laptop.GetId(), Score: 2, ReviewTitle: "Meh again"})
+	requireRating(2, 6)
+
+	_, err = laptopClient.ModerateReview(admin, &pb.ModerateReviewRequest{ReviewId: res.GetReviewId()})
+	require.NoError(t, err)
+	requireRating(2, 6)
+}
+
+func TestClientRatingLimits(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+
+	laptop1 := sample.NewLaptop()
+	laptop2 := sample.NewLaptop()
+	for _, laptop := range []*pb.Laptop{laptop1, laptop2} {
+		err := laptopStore.Save(laptop)
+		require.NoError(t, err)
+	}
+
+	laptopServer := service.NewLaptopServer(laptopStore, nil, service.NewInMemoryRatingStore())
+	laptopServer.RatingLimiter = service.NewRatingLimiter()
+	laptopServer.RatingLimiter.UserLimit = 2
+	laptopServer.RatingLimiter.BurstLimit = 2
+
+	serverAddr, jwtManager := serveTestAuthLaptopServer(t, laptopServer)
+	laptopClient := newTestLaptopClient(t, serverAddr)
+
+	user1 := newTestUserContext(t, jwtManager, "user1", "user")
+	rateTestLaptops := func(ctx context.Context, laptopIDs []string, expected []codes.Code) {
+		stream, err := laptopClient.RateLaptop(ctx)
+		require.NoError(t, err)
+
+		for i, laptopID := range laptopIDs {
+			require.NoError(t, stream.Send(&pb.RateLaptopRequest{LaptopId: laptopID, Score: 8}))
+
+			res, err := stream.Recv()
+			require.NoError(t, err)
+			require.Equal(t, expected[i], codes.Code(res.GetCode()), res.GetMessage())
 		}
+
+		require.NoError(t, stream.CloseSend())
+		_, err = stream.Recv()
+		require.Equal(t, io.EOF, err)
+	}
+
+	// the user limit holds across streams
+	rateTestLaptops(user1,
+		[]string{laptop1.GetId(), laptop2.GetId(), laptop1.GetId()},
+		[]codes.Code{codes.OK, codes.OK, codes.ResourceExhausted},
+	)
+	rateTestLaptops(user1, []string{laptop2.GetId()}, []codes.Code{codes.ResourceExhausted})
+
+	// the test accounts are new, so only two can rate a laptop
+	rateTestLaptops(newTestUserContext(t, jwtManager, "user2", "user"), []string{laptop1.GetId()}, []codes.Code{codes.OK})
+	rateTestLaptops(newTestUserContext(t, jwtManager, "user3", "user"), []string{laptop1.GetId()}, []codes.Code{codes.ResourceExhausted})
+
+	// accounts of unknown age aren't new
+	user4, err := service.NewUser("user4", "secret", "user")
+	require.NoError(t, err)
+	user4.CreatedAt = time.Time{}
+
+	accessToken, err := jwtManager.Generate(user4)
+	require.NoError(t, err)
+
+	claims, err := jwtManager.Verify(accessToken)
+	require.NoError(t, err)
+	require.Zero(t, claims.CreatedAt)
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", accessToken)
+	rateTestLaptops(ctx, []string{laptop1.GetId()}, []codes.Code{codes.OK})
+
+	ratingRes, err := laptopClient.GetRating(context.Background(), &pb.GetRatingRequest{LaptopId: laptop1.GetId()})
+	require.NoError(t, err)
+	require.Equal(t, uint32(3), ratingRes.GetRatedCount())
+
+	_, err = laptopClient.ListRatingAudit(user1, &pb.ListRatingAuditRequest{})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	admin := newTestUserContext(t, jwtManager, "admin1", "admin")
+
+	auditRes, err := laptopClient.ListRatingAudit(admin, &pb.ListRatingAuditRequest{})
+	require.NoError(t, err)
+	require.Len(t, auditRes.GetEntries(), 3)
+	require.Equal(t, "user3", auditRes.GetEntries()[0].GetUsername())
+	require.Equal(t, laptop1.GetId(), auditRes.GetEntries()[0].GetLaptopId())
+	require.NotEmpty(t, auditRes.GetEntries()[0].GetReason())
+
+	auditRes, err = laptopClient.ListRatingAudit(admin, &pb.ListRatingAuditRequest{Username: "user1"})
+	require.NoError(t, err)
+	require.Len(t, auditRes.GetEntries(), 2)
+	require.Equal(t, laptop2.GetId(), auditRes.GetEntries()[0].GetLaptopId())
+	require.Equal(t, laptop1.GetId(), auditRes.GetEntries()[1].GetLaptopId())
+}
+
+func TestClientRatingScoreValidation(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	ratingStore := service.NewInMemoryRatingStore()
+
+	laptop := sample.NewLaptop()
+	err := laptopStore.Save(laptop)
+	require.NoError(t, err)
+
+	laptopServer := service.NewLaptopServer(laptopStore, nil, ratingStore)
+	laptopServer.RatingScale.Step = 0.5
+
+	serverAddr, jwtManager := serveTestAuthLaptopServer(t, laptopServer)
+	laptopClient := newTestLaptopClient(t, serverAddr)
+
+	configRes, err := laptopClient.GetRatingConfig(context.Background(), &pb.GetRatingConfigRequest{})
+	require.NoError(t, err)
+	require.Equal(t, 1.0, configRes.GetMinScore())
+	require.Equal(t, 10.0, configRes.GetMaxScore())
+	require.Equal(t, 0.5, configRes.GetScoreStep())
+
+	stream, err := laptopClient.RateLaptop(newTestUserContext(t, jwtManager, "user1", "user"))
+	require.NoError(t, err)
+
+	testCases := []struct {
+		score float64
+		code  codes.Code
+	}{
+		{score: 7, code: codes.OK},
+		{score: -1, code: codes.InvalidArgument},
+		{score: math.NaN(), code: codes.InvalidArgument},
+		{score: math.Inf(1), code: codes.InvalidArgument},
+		{score: 1e9, code: codes.InvalidArgument},
+		{score: 0.5, code: codes.InvalidArgument},
+		{score: 7.3, code: codes.InvalidArgument},
+		{score: 10, code: codes.OK},
+		{score: 8.5, code: codes.OK},
+	}
+
+	for _, tc := range testCases {
+		err := stream.Send(&pb.RateLaptopRequest{LaptopId: laptop.GetId(), Score: tc.score})
+		require.NoError(t, err)
+	}
+	require.NoError(t, stream.CloseSend())
+
+	// invalid scores are reported one by one, without ending the stream
+	for _, tc := range testCases {
+		res, err := stream.Recv()
 		require.NoError(t, err)
 		require.Equal(t, laptop.GetId(), res.GetLaptopId())
-		require.Equal(t, uint32(idx+1), res.GetRatedCount())
-		require.Equal(t, expectedAverageScore[idx], res.GetAverageScore())
+		require.Equal(t, tc.code, codes.Code(res.GetCode()), "score %v", tc.score)
+
+		if tc.code == codes.OK {
+			require.Equal(t, tc.score, res.GetAverageScore())
+		} else {
+			require.NotEmpty(t, res.GetMessage())
+		}
+	}
+
+	_, err = stream.Recv()
+	require.Equal(t, io.EOF, err)
+}
+
+func TestClientRatingUnknownLaptop(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	ratingStore := service.NewInMemoryRatingStore()
+
+	laptop1 := sample.NewLaptop()
+	laptop2 := sample.NewLaptop()
+	for _, laptop := range []*pb.Laptop{laptop1, laptop2} {
+		err := laptopStore.Save(laptop)
+		require.NoError(t, err)
+	}
+
+	laptopServer := service.NewLaptopServer(laptopStore, nil, ratingStore)
+	serverAddr, jwtManager := serveTestAuthLaptopServer(t, laptopServer)
+	laptopClient := newTestLaptopClient(t, serverAddr)
+
+	stream, err := laptopClient.RateLaptop(newTestUserContext(t, jwtManager, "user1", "user"))
+	require.NoError(t, err)
+
+	testCases := []struct {
+		laptopID string
+		score    float64
+		code     codes.Code
+	}{
+		{laptopID: laptop1.GetId(), score: 8, code: codes.OK},
+		{laptopID: "unknown", score: 9, code: codes.NotFound},
+		{laptopID: "", score: 9, code: codes.NotFound},
+		{laptopID: laptop2.GetId(), score: 6, code: codes.OK},
+		{laptopID: "unknown", score: 0, code: codes.InvalidArgument},
+		{laptopID: laptop1.GetId(), score: 0, code: codes.InvalidArgument},
+		{laptopID: "unknown", score: 5, code: codes.NotFound},
+		{laptopID: laptop1.GetId(), score: 10, code: codes.OK},
+	}
+
+	for _, tc := range testCases {
+		err := stream.Send(&pb.RateLaptopRequest{LaptopId: tc.laptopID, Score: tc.score})
+		require.NoError(t, err)
+	}
+	require.NoError(t, stream.CloseSend())
+
+	// unknown laptops are reported one by one, without ending the stream
+	for i, tc := range testCases {
+		res, err := stream.Recv()
+		require.NoError(t, err)
+		require.Equal(t, tc.laptopID, res.GetLaptopId())
+		require.Equal(t, tc.code, codes.Code(res.GetCode()), "request %d", i)
+
+		if tc.code == codes.OK {
+			require.Equal(t, tc.score, res.GetAverageScore())
+		} else {
+			require.NotEmpty(t, res.GetMessage())
+			require.Zero(t, res.GetRatedCount())
+		}
+	}
+
+	_, err = stream.Recv()
+	require.Equal(t, io.EOF, err)
+
+	for laptopID, expected := range map[string]float64{laptop1.GetId(): 10, laptop2.GetId(): 6} {
+		rating, err := ratingStore.Find(laptopID)
+		require.NoError(t, err)
+		require.Equal(t, uint32(1), rating.Count)
+		require.Equal(t, expected, rating.Mean())
 	}
 
+	_, err = ratingStore.Find("unknown")
+	require.Equal(t, service.ErrNotFound, err)
 }
 
 func TestClientUploadImage(t *testing.T) {
@@ -70,7 +710,7 @@ func TestClientUploadImage(t *testing.T) {
 	testImageFolder := "../tmp"
 
 	laptopStore := service.NewInMemoryLaptopStore()
-	imageStore := service.NewDiskImageStore(testImageFolder)
+	imageStore, imageFolder := newTestImageStore(t)
 
 	laptop := sample.NewLaptop()
 	err := laptopStore.Save(laptop)
@@ -126,17 +766,72 @@ func TestClientUploadImage(t *testing.T) {
 	fSize, _ := strconv.Atoi(res.GetSize())
 	require.Equal(t, size, fSize)
 
-	savedImagePath := fmt.Sprintf("%s/%s%s", testImageFolder, res.GetId(), filepath.Ext(imagePath))
+	content, err := ioutil.ReadFile(imagePath)
+	require.NoError(t, err)
+	checksum := sha256.Sum256(content)
+	require.Equal(t, hex.EncodeToString(checksum[:]), res.GetChecksum())
+
+	savedImagePath := fmt.Sprintf("%s/%s%s", imageFolder, res.GetChecksum(), filepath.Ext(imagePath))
 	require.FileExists(t, savedImagePath)
-	require.NoError(t, os.Remove(savedImagePath))
 }
 
-func TestClientCreateLaptop(t *testing.T) {
+func TestClientUploadImageStripMetadata(t *testing.T) {
 	t.Parallel()
 
 	laptopStore := service.NewInMemoryLaptopStore()
-	serverAddr := startTestLaptopServer(t, laptopStore, nil, nil)
-	laptopClient := newTestLaptopClient(t, serverAddr)
+	imageStore, imageFolder := newTestImageStore(t)
+	imageStore.StripMetadata = true
+
+	laptop := sample.NewLaptop()
+	err := laptopStore.Save(laptop)
+	require.NoError(t, err)
+
+	serverAddr := startTestLaptopServer(t, laptopStore, imageStore, nil)
+	laptopClient := newTestLaptopClient(t, serverAddr)
+
+	imagePath := "../tmp/laptop_exif.jpg"
+	content, err := ioutil.ReadFile(imagePath)
+	require.NoError(t, err)
+
+	stream, err := laptopClient.UploadImage(context.Background())
+	require.NoError(t, err)
+
+	err = stream.Send(&pb.UploadImageRequest{
+		Data: &pb.UploadImageRequest_Info{
+			Info: &pb.ImageInfo{
+				LaptopId:  laptop.GetId(),
+				ImageType: filepath.Ext(imagePath),
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	err = stream.Send(&pb.UploadImageRequest{
+		Data: &pb.UploadImageRequest_Chunk{
+			Chunk: content,
+		},
+	})
+	require.NoError(t, err)
+
+	res, err := stream.CloseAndRecv()
+	require.NoError(t, err)
+
+	// the size and checksum are the ones of the stored image, without its metadata
+	stored, err := ioutil.ReadFile(fmt.Sprintf("%s/%s%s", imageFolder, res.GetChecksum(), filepath.Ext(imagePath)))
+	require.NoError(t, err)
+	require.Less(t, len(stored), len(content))
+	require.Equal(t, strconv.Itoa(len(stored)), res.GetSize())
+
+	checksum := sha256.Sum256(stored)
+	require.Equal(t, hex.EncodeToString(checksum[:]), res.GetChecksum())
+}
+
+func TestClientCreateLaptop(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	serverAddr := startTestLaptopServer(t, laptopStore, nil, nil)
+	laptopClient := newTestLaptopClient(t, serverAddr)
 
 	laptop := sample.NewLaptop()
 	expectedID := laptop.Id
@@ -154,12 +849,26 @@ func TestClientCreateLaptop(t *testing.T) {
 	require.True(t, present)
 	require.NotNil(t, other)
 
+	// Memories are stored in the canonical unit
+	require.Equal(t, memory.CanonicalUnit, other.GetRam().GetUnit())
+	require.Equal(t, pb.Memory_GIGABYTE, other.GetRam().GetDisplayUnit())
+	require.Equal(t, 0, memory.Compare(laptop.GetRam(), other.GetRam()))
+
 }
 
 func startTestLaptopServer(t *testing.T, laptopStore service.LaptopStore, imageStore *service.DiskImageStore, ratingStore service.RatingStore) string {
-	laptopServer := service.NewLaptopServer(laptopStore, imageStore, ratingStore)
+	// a nil *DiskImageStore would make a non-nil ImageStore
+	var images service.ImageStore
+	if imageStore != nil {
+		images = imageStore
+	}
+
+	laptopServer := service.NewLaptopServer(laptopStore, images, ratingStore)
+	return serveTestLaptopServer(t, laptopServer)
+}
 
-	grpcServer := grpc.NewServer()
+func serveTestLaptopServer(t *testing.T, laptopServer *service.LaptopServer, opts ...grpc.ServerOption) string {
+	grpcServer := grpc.NewServer(opts...)
 
 	pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
 
@@ -172,6 +881,56 @@ func startTestLaptopServer(t *testing.T, laptopStore service.LaptopStore, imageS
 	return listener.Addr().String()
 }
 
+// testAccessibleRoles are the roles that can call the RPCs needing an authenticated user in tests
+var testAccessibleRoles = map[string][]string{
+	"/techschool.pcbook.LaptopService/UploadImage":        {"admin"},
+	"/techschool.pcbook.LaptopService/StartImageUpload":   {"admin"},
+	"/techschool.pcbook.LaptopService/GetUploadStatus":    {"admin"},
+	"/techschool.pcbook.LaptopService/RateLaptop":         {"admin", "user"},
+	"/techschool.pcbook.LaptopService/DeleteReview":       {"admin", "user"},
+	"/techschool.pcbook.LaptopService/ListPendingReviews": {"admin"},
+	"/techschool.pcbook.LaptopService/ModerateReview":     {"admin"},
+	"/techschool.pcbook.LaptopService/ListMyReviews":      {"admin", "user"},
+	"/techschool.pcbook.LaptopService/ListRatingAudit":    {"admin"},
+}
+
+// serveTestAuthLaptopServer serves the laptop server behind the auth interceptor.
+// It returns the server address and the JWT manager signing the access tokens.
+func serveTestAuthLaptopServer(t *testing.T, laptopServer *service.LaptopServer) (string, *service.JWTManager) {
+	jwtManager := service.NewJWTManager("secret", time.Minute)
+	interceptor := service.NewAuthInterceptor(jwtManager, testAccessibleRoles)
+
+	serverAddr := serveTestLaptopServer(t, laptopServer,
+		grpc.UnaryInterceptor(interceptor.Unary()),
+		grpc.StreamInterceptor(interceptor.Stream()),
+	)
+
+	return serverAddr, jwtManager
+}
+
+// newTestUserContext returns a context carrying an access token of the user
+func newTestUserContext(t *testing.T, jwtManager *service.JWTManager, username string, role string) context.Context {
+	user, err := service.NewUser(username, "secret", role)
+	require.NoError(t, err)
+
+	accessToken, err := jwtManager.Generate(user)
+	require.NoError(t, err)
+
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", accessToken)
+}
+
+// newTestImageStore returns an image store in a temporary folder that is removed after the test
+func newTestImageStore(t *testing.T) (*service.DiskImageStore, string) {
+	imageFolder, err := ioutil.TempDir("", "img")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(imageFolder) })
+
+	imageStore, err := service.NewDiskImageStore(imageFolder)
+	require.NoError(t, err)
+
+	return imageStore, imageFolder
+}
+
 func newTestLaptopClient(t *testing.T, serverAddrString string) pb.LaptopServiceClient {
 	conn, err := grpc.Dial(serverAddrString, grpc.WithInsecure())
 	require.NoError(t, err)
@@ -246,3 +1005,629 @@ func TestClientSearchCreateLaptop(t *testing.T) {
 
 	require.Equal(t, len(expectedIds), found)
 }
+
+func TestClientSearchLaptopReadOnly(t *testing.T) {
+	t.Parallel()
+
+	store := service.NewInMemoryLaptopStore()
+	expectedLaptops := make(map[string]*pb.Laptop)
+
+	for i := 0; i < 3; i++ {
+		laptop := sample.NewLaptop()
+		err := store.Save(laptop)
+		require.NoError(t, err)
+		expectedLaptops[laptop.GetId()] = laptop
+	}
+
+	laptopServer := service.NewLaptopServer(store, nil, nil)
+	laptopServer.ReadOnlySearch = true
+
+	serverAddr := serveTestLaptopServer(t, laptopServer)
+	laptopClient := newTestLaptopClient(t, serverAddr)
+
+	req := &pb.SearchLaptopRequest{Filter: &pb.Filter{MaxPriceUsd: 5000}}
+	stream, err := laptopClient.SearchLaptop(context.Background(), req)
+	require.NoError(t, err)
+
+	found := 0
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+
+		require.NoError(t, err)
+		expected, ok := expectedLaptops[res.GetLaptop().GetId()]
+		require.True(t, ok)
+		require.True(t, proto.Equal(expected, res.GetLaptop()))
+
+		found++
+	}
+
+	require.Equal(t, len(expectedLaptops), found)
+}
+
+func TestClientSearchLaptopSortedByRating(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+
+	laptopIDs := make(map[string]string)
+	for _, name := range []string{"unrated", "old", "new"} {
+		laptop := sample.NewLaptop()
+		err := laptopStore.Save(laptop)
+		require.NoError(t, err)
+		laptopIDs[name] = laptop.GetId()
+	}
+
+	now := time.Date(2020, time.June, 1, 0, 0, 0, 0, time.UTC)
+
+	ratingStore := service.NewInMemoryRatingStore()
+	ratingStore.Now = func() time.Time { return now }
+
+	// the old laptop has the best average, but the new one is better lately
+	_, _, err := ratingStore.Add(laptopIDs["old"], "user1", 10)
+	require.NoError(t, err)
+	now = now.Add(365 * 24 * time.Hour)
+	_, _, err = ratingStore.Add(laptopIDs["old"], "user2", 4)
+	require.NoError(t, err)
+	_, _, err = ratingStore.Add(laptopIDs["new"], "user1", 6)
+	require.NoError(t, err)
+
+	for _, readOnly := range []bool{false, true} {
+		laptopServer := service.NewLaptopServer(laptopStore, nil, ratingStore)
+		laptopServer.ReadOnlySearch = readOnly
+
+		serverAddr := serveTestLaptopServer(t, laptopServer)
+		laptopClient := newTestLaptopClient(t, serverAddr)
+
+		testCases := []struct {
+			sortBy   pb.SearchLaptopRequest_SortBy
+			expected []string
+		}{
+			{pb.SearchLaptopRequest_AVERAGE_SCORE, []string{"old", "new", "unrated"}},
+			{pb.SearchLaptopRequest_RECENT_AVERAGE_SCORE, []string{"new", "old", "unrated"}},
+			{pb.SearchLaptopRequest_DECAYED_SCORE, []string{"new", "old", "unrated"}},
+		}
+
+		for _, tc := range testCases {
+			req := &pb.SearchLaptopRequest{Filter: &pb.Filter{MaxPriceUsd: 5000}, SortBy: tc.sortBy}
+			stream, err := laptopClient.SearchLaptop(context.Background(), req)
+			require.NoError(t, err)
+
+			for _, name := range tc.expected {
+				res, err := stream.Recv()
+				require.NoError(t, err)
+				require.Equal(t, laptopIDs[name], res.GetLaptop().GetId(), "sorted by %v", tc.sortBy)
+			}
+
+			_, err = stream.Recv()
+			require.Equal(t, io.EOF, err)
+		}
+	}
+}
+
+func TestClientCreateLaptops(t *testing.T) {
+	t.Parallel()
+
+	laptopDuplicate := sample.NewLaptop()
+	laptopInvalidID := sample.NewLaptop()
+	laptopInvalidID.Id = "Jibberish"
+	laptopNoBrand := sample.NewLaptop()
+	laptopNoBrand.Brand = ""
+
+	testCases := []struct {
+		name         string
+		allOrNothing bool
+		codes        []codes.Code
+	}{
+		{
+			name:  "partial",
+			codes: []codes.Code{codes.OK, codes.InvalidArgument, codes.OK, codes.AlreadyExists, codes.InvalidArgument},
+		}, {
+			name:         "all_or_nothing",
+			allOrNothing: true,
+			codes:        []codes.Code{codes.Aborted, codes.InvalidArgument, codes.Aborted, codes.Aborted, codes.InvalidArgument},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			laptopStore := service.NewInMemoryLaptopStore()
+			err := laptopStore.Save(laptopDuplicate)
+			require.NoError(t, err)
+
+			serverAddr := startTestLaptopServer(t, laptopStore, nil, nil)
+			laptopClient := newTestLaptopClient(t, serverAddr)
+
+			laptops := []*pb.Laptop{sample.NewLaptop(), laptopInvalidID, sample.NewLaptop(), laptopDuplicate, laptopNoBrand}
+
+			stream, err := laptopClient.CreateLaptops(context.Background())
+			require.NoError(t, err)
+
+			for _, laptop := range laptops {
+				err := stream.Send(&pb.CreateLaptopsRequest{Laptop: laptop, AllOrNothing: tc.allOrNothing})
+				require.NoError(t, err)
+			}
+
+			res, err := stream.CloseAndRecv()
+			require.NoError(t, err)
+			require.Len(t, res.GetResults(), len(laptops))
+
+			created := uint32(0)
+			for i, result := range res.GetResults() {
+				require.Equal(t, tc.codes[i], codes.Code(result.GetCode()))
+				require.Equal(t, laptops[i].GetId(), result.GetId())
+
+				_, found := laptopStore.Find(laptops[i].GetId())
+				require.Equal(t, tc.codes[i] == codes.OK || laptops[i] == laptopDuplicate, found)
+				if tc.codes[i] == codes.OK {
+					created++
+				}
+			}
+
+			// the invalid fields are reported as by CreateLaptop
+			violations := res.GetResults()[4].GetFieldViolations()
+			require.Len(t, violations, 1)
+			require.Equal(t, "laptop.brand", violations[0].GetField())
+			require.Equal(t, "is required", violations[0].GetDescription())
+			require.Empty(t, res.GetResults()[1].GetFieldViolations())
+
+			require.Equal(t, created, res.GetCreatedCount())
+			require.Equal(t, uint32(len(laptops))-created, res.GetFailedCount())
+		})
+	}
+}
+
+func TestClientCreateLaptopsTooMany(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	laptopServer := service.NewLaptopServer(laptopStore, nil, nil)
+	laptopServer.MaxAllOrNothingLaptops = 2
+
+	serverAddr := serveTestLaptopServer(t, laptopServer)
+	laptopClient := newTestLaptopClient(t, serverAddr)
+
+	for _, allOrNothing := range []bool{false, true} {
+		stream, err := laptopClient.CreateLaptops(context.Background())
+		require.NoError(t, err)
+
+		for i := 0; i < 3; i++ {
+			err := stream.Send(&pb.CreateLaptopsRequest{Laptop: sample.NewLaptop(), AllOrNothing: allOrNothing})
+			if err == io.EOF {
+				// the server ended the stream
+				break
+			}
+			require.NoError(t, err)
+		}
+
+		res, err := stream.CloseAndRecv()
+		if !allOrNothing {
+			// only all or nothing batches are held until the stream ends
+			require.NoError(t, err)
+			require.Equal(t, uint32(3), res.GetCreatedCount())
+			continue
+		}
+		require.Equal(t, codes.ResourceExhausted, status.Code(err))
+	}
+}
+
+func TestClientDownloadImage(t *testing.T) {
+	t.Parallel()
+
+	testImageFolder := "../tmp"
+
+	imageStore, _ := newTestImageStore(t)
+
+	imagePath := fmt.Sprintf("%v/laptop.jpg", testImageFolder)
+	content, err := ioutil.ReadFile(imagePath)
+	require.NoError(t, err)
+
+	imageID, err := imageStore.Save(uuid.New().String(), filepath.Ext(imagePath), "admin1", bytes.NewReader(content))
+	require.NoError(t, err)
+
+	laptopServer := service.NewLaptopServer(service.NewInMemoryLaptopStore(), imageStore, nil)
+	laptopServer.DownloadChunkSize = 1024
+
+	serverAddr := serveTestLaptopServer(t, laptopServer)
+	laptopClient := newTestLaptopClient(t, serverAddr)
+
+	for _, offset := range []int64{0, 1000, int64(len(content))} {
+		req := &pb.DownloadImageRequest{ImageId: imageID, Offset: offset}
+		stream, err := laptopClient.DownloadImage(context.Background(), req)
+		require.NoError(t, err)
+
+		res, err := stream.Recv()
+		require.NoError(t, err)
+		require.Equal(t, imageID, res.GetInfo().GetImageId())
+		require.Equal(t, int64(len(content)), res.GetInfo().GetSize())
+
+		checksum := sha256.Sum256(content)
+		require.Equal(t, hex.EncodeToString(checksum[:]), res.GetInfo().GetChecksum())
+
+		downloaded := bytes.Buffer{}
+		for {
+			res, err := stream.Recv()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			require.LessOrEqual(t, len(res.GetChunk()), 1024)
+			downloaded.Write(res.GetChunk())
+		}
+
+		require.True(t, bytes.Equal(content[offset:], downloaded.Bytes()))
+	}
+
+	stream, err := laptopClient.DownloadImage(context.Background(), &pb.DownloadImageRequest{ImageId: "unknown"})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	// a chunk size that isn't positive falls back to the default
+	for _, chunkSize := range []int{0, -1} {
+		laptopServer := service.NewLaptopServer(service.NewInMemoryLaptopStore(), imageStore, nil)
+		laptopServer.DownloadChunkSize = chunkSize
+
+		laptopClient := newTestLaptopClient(t, serveTestLaptopServer(t, laptopServer))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		stream, err := laptopClient.DownloadImage(ctx, &pb.DownloadImageRequest{ImageId: imageID})
+		require.NoError(t, err)
+
+		downloaded := bytes.Buffer{}
+		for {
+			res, err := stream.Recv()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			downloaded.Write(res.GetChunk())
+		}
+
+		require.True(t, bytes.Equal(content, downloaded.Bytes()))
+	}
+}
+
+func TestClientDownloadImageThumbnail(t *testing.T) {
+	t.Parallel()
+
+	imageStore, _ := newTestImageStore(t)
+
+	imageID, err := imageStore.Save(uuid.New().String(), ".png", "admin1", bytes.NewReader(newTestPNG(t, 300, 600)))
+	require.NoError(t, err)
+
+	serverAddr := startTestLaptopServer(t, service.NewInMemoryLaptopStore(), imageStore, nil)
+	laptopClient := newTestLaptopClient(t, serverAddr)
+
+	testCases := []struct {
+		name          string
+		thumbnailSize uint32
+		width         int
+		height        int
+		code          codes.Code
+	}{
+		{name: "original", thumbnailSize: 0, width: 300, height: 600, code: codes.OK},
+		{name: "thumbnail", thumbnailSize: 128, width: 64, height: 128, code: codes.OK},
+		{name: "original_fits_in_size", thumbnailSize: 1024, width: 300, height: 600, code: codes.OK},
+		{name: "size_not_generated", thumbnailSize: 256, code: codes.NotFound},
+	}
+
+	for _, tc := range testCases {
+		req := &pb.DownloadImageRequest{ImageId: imageID, ThumbnailSize: tc.thumbnailSize}
+		stream, err := laptopClient.DownloadImage(context.Background(), req)
+		require.NoError(t, err, tc.name)
+
+		res, err := stream.Recv()
+		require.Equal(t, tc.code, status.Code(err), tc.name)
+		if tc.code != codes.OK {
+			continue
+		}
+
+		info := res.GetInfo()
+		require.Equal(t, []uint32{128, 512}, info.GetThumbnailSizes(), tc.name)
+		require.Equal(t, uint32(tc.width), info.GetWidth(), tc.name)
+		require.Equal(t, uint32(tc.height), info.GetHeight(), tc.name)
+
+		downloaded := bytes.Buffer{}
+		for {
+			res, err := stream.Recv()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err, tc.name)
+			downloaded.Write(res.GetChunk())
+		}
+
+		require.Equal(t, info.GetSize(), int64(downloaded.Len()), tc.name)
+		checksum := sha256.Sum256(downloaded.Bytes())
+		require.Equal(t, hex.EncodeToString(checksum[:]), info.GetChecksum(), tc.name)
+
+		config, err := png.DecodeConfig(&downloaded)
+		require.NoError(t, err, tc.name)
+		require.Equal(t, tc.width, config.Width, tc.name)
+		require.Equal(t, tc.height, config.Height, tc.name)
+	}
+}
+
+func TestClientListAndDeleteImages(t *testing.T) {
+	t.Parallel()
+
+	testImageFolder := "../tmp"
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	imageStore, imageFolder := newTestImageStore(t)
+
+	laptop := sample.NewLaptop()
+	err := laptopStore.Save(laptop)
+	require.NoError(t, err)
+
+	content, err := ioutil.ReadFile(fmt.Sprintf("%v/laptop.jpg", testImageFolder))
+	require.NoError(t, err)
+
+	imageIDs := make([]string, 2)
+	for i := range imageIDs {
+		imageIDs[i], err = imageStore.Save(laptop.GetId(), ".jpg", "admin1", bytes.NewReader(content))
+		require.NoError(t, err)
+	}
+
+	serverAddr := startTestLaptopServer(t, laptopStore, imageStore, nil)
+	laptopClient := newTestLaptopClient(t, serverAddr)
+
+	listRes, err := laptopClient.ListImages(context.Background(), &pb.ListImagesRequest{LaptopId: laptop.GetId()})
+	require.NoError(t, err)
+	require.Len(t, listRes.GetImages(), 2)
+	for i, image := range listRes.GetImages() {
+		require.Equal(t, imageIDs[i], image.GetImageId())
+		require.Equal(t, laptop.GetId(), image.GetLaptopId())
+		require.Equal(t, int64(len(content)), image.GetSize())
+	}
+
+	for _, imageID := range imageIDs {
+		_, err = laptopClient.DeleteImage(context.Background(), &pb.DeleteImageRequest{ImageId: imageID})
+		require.NoError(t, err)
+		require.NoFileExists(t, fmt.Sprintf("%s/%s.jpg", imageFolder, imageID))
+	}
+
+	listRes, err = laptopClient.ListImages(context.Background(), &pb.ListImagesRequest{LaptopId: laptop.GetId()})
+	require.NoError(t, err)
+	require.Empty(t, listRes.GetImages())
+
+	_, err = laptopClient.DeleteImage(context.Background(), &pb.DeleteImageRequest{ImageId: imageIDs[0]})
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestClientUploadImageTooLarge(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	imageStore, imageFolder := newTestImageStore(t)
+
+	laptop := sample.NewLaptop()
+	err := laptopStore.Save(laptop)
+	require.NoError(t, err)
+
+	laptopServer := service.NewLaptopServer(laptopStore, imageStore, nil)
+	laptopServer.MaxImageSize = 2048
+
+	serverAddr := serveTestLaptopServer(t, laptopServer)
+	laptopClient := newTestLaptopClient(t, serverAddr)
+
+	stream, err := laptopClient.UploadImage(context.Background())
+	require.NoError(t, err)
+
+	req := &pb.UploadImageRequest{
+		Data: &pb.UploadImageRequest_Info{
+			Info: &pb.ImageInfo{LaptopId: laptop.GetId(), ImageType: ".jpg"},
+		},
+	}
+	require.NoError(t, stream.Send(req))
+
+	for i := 0; i < 3; i++ {
+		req := &pb.UploadImageRequest{
+			Data: &pb.UploadImageRequest_Chunk{Chunk: make([]byte, 1024)},
+		}
+		if stream.Send(req) != nil {
+			break
+		}
+	}
+
+	_, err = stream.CloseAndRecv()
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// the partially written image is removed
+	files, err := ioutil.ReadDir(imageFolder)
+	require.NoError(t, err)
+	require.Empty(t, files)
+}
+
+func TestClientUploadImageResumable(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	imageStore, _ := newTestImageStore(t)
+
+	laptop := sample.NewLaptop()
+	err := laptopStore.Save(laptop)
+	require.NoError(t, err)
+
+	laptopServer := service.NewLaptopServer(laptopStore, imageStore, nil)
+	serverAddr, jwtManager := serveTestAuthLaptopServer(t, laptopServer)
+	laptopClient := newTestLaptopClient(t, serverAddr)
+
+	admin1 := newTestUserContext(t, jwtManager, "admin1", "admin")
+	admin2 := newTestUserContext(t, jwtManager, "admin2", "admin")
+
+	content, err := ioutil.ReadFile("../tmp/laptop.jpg")
+	require.NoError(t, err)
+
+	startRes, err := laptopClient.StartImageUpload(admin1, &pb.StartImageUploadRequest{
+		Info: &pb.ImageInfo{LaptopId: laptop.GetId(), ImageType: ".jpg"},
+		Size: int64(len(content)),
+	})
+	require.NoError(t, err)
+	sessionID := startRes.GetSessionId()
+
+	// sends content from offset to end in 1 kb chunks
+	upload := func(ctx context.Context, offset int, end int) (*pb.UploadImageResponse, error) {
+		stream, err := laptopClient.UploadImage(ctx)
+		require.NoError(t, err)
+
+		for ; offset < end; offset += 1024 {
+			chunkEnd := offset + 1024
+			if chunkEnd > end {
+				chunkEnd = end
+			}
+
+			req := &pb.UploadImageRequest{
+				Data:      &pb.UploadImageRequest_Chunk{Chunk: content[offset:chunkEnd]},
+				SessionId: sessionID,
+				Offset:    int64(offset),
+			}
+			if stream.Send(req) != nil {
+				break
+			}
+		}
+
+		return stream.CloseAndRecv()
+	}
+
+	// interrupted upload
+	half := len(content) / 2
+	_, err = upload(admin1, 0, half)
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	statusRes, err := laptopClient.GetUploadStatus(admin1, &pb.GetUploadStatusRequest{SessionId: sessionID})
+	require.NoError(t, err)
+	require.Equal(t, int64(half), statusRes.GetReceivedBytes())
+	require.Equal(t, int64(len(content)), statusRes.GetSize())
+
+	// chunks must continue at the received offset
+	_, err = upload(admin1, half+1024, len(content))
+	require.Equal(t, codes.OutOfRange, status.Code(err))
+
+	// only the uploader who started the session can send its chunks, or see its status
+	_, err = upload(admin2, half, len(content))
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = laptopClient.GetUploadStatus(admin2, &pb.GetUploadStatusRequest{SessionId: sessionID})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	res, err := upload(admin1, int(statusRes.GetReceivedBytes()), len(content))
+	require.NoError(t, err)
+	require.Equal(t, strconv.Itoa(len(content)), res.GetSize())
+
+	info, err := imageStore.Find(res.GetId())
+	require.NoError(t, err)
+	checksum := sha256.Sum256(content)
+	require.Equal(t, hex.EncodeToString(checksum[:]), info.Checksum)
+
+	saved, err := ioutil.ReadFile(info.Path)
+	require.NoError(t, err)
+	require.True(t, bytes.Equal(content, saved))
+
+	_, err = laptopClient.GetUploadStatus(admin1, &pb.GetUploadStatusRequest{SessionId: sessionID})
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestClientImageOrder(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	imageStore, imageFolder := newTestImageStore(t)
+
+	laptop := sample.NewLaptop()
+	err := laptopStore.Save(laptop)
+	require.NoError(t, err)
+
+	imageIDs := make([]string, 3)
+	for i := range imageIDs {
+		imageIDs[i], err = imageStore.Save(laptop.GetId(), ".png", "admin1", bytes.NewReader(newTestPNG(t, 40+i, 30)))
+		require.NoError(t, err)
+	}
+
+	for _, readOnlySearch := range []bool{false, true} {
+		laptopServer := service.NewLaptopServer(laptopStore, imageStore, nil)
+		laptopServer.ReadOnlySearch = readOnlySearch
+
+		serverAddr := serveTestLaptopServer(t, laptopServer)
+		laptopClient := newTestLaptopClient(t, serverAddr)
+
+		requireSearchPrimaryImage(t, laptopClient, laptop.GetId(), imageIDs[0])
+
+		primaryRes, err := laptopClient.SetPrimaryImage(context.Background(), &pb.SetPrimaryImageRequest{ImageId: imageIDs[2]})
+		require.NoError(t, err)
+		requireImageOrder(t, []string{imageIDs[2], imageIDs[0], imageIDs[1]}, primaryRes.GetImages())
+		requireSearchPrimaryImage(t, laptopClient, laptop.GetId(), imageIDs[2])
+
+		order := []string{imageIDs[1], imageIDs[0], imageIDs[2]}
+		reorderReq := &pb.ReorderImagesRequest{LaptopId: laptop.GetId(), ImageIds: order}
+		reorderRes, err := laptopClient.ReorderImages(context.Background(), reorderReq)
+		require.NoError(t, err)
+		requireImageOrder(t, order, reorderRes.GetImages())
+		requireSearchPrimaryImage(t, laptopClient, laptop.GetId(), imageIDs[1])
+
+		// restore the upload order for the next iteration
+		reorderReq.ImageIds = imageIDs
+		_, err = laptopClient.ReorderImages(context.Background(), reorderReq)
+		require.NoError(t, err)
+	}
+
+	laptopClient := newTestLaptopClient(t, startTestLaptopServer(t, laptopStore, imageStore, nil))
+
+	invalidOrders := [][]string{
+		imageIDs[:2],
+		{imageIDs[0], imageIDs[0], imageIDs[1]},
+		{imageIDs[0], imageIDs[1], "unknown"},
+	}
+	for _, order := range invalidOrders {
+		_, err = laptopClient.ReorderImages(context.Background(), &pb.ReorderImagesRequest{LaptopId: laptop.GetId(), ImageIds: order})
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+	}
+
+	_, err = laptopClient.SetPrimaryImage(context.Background(), &pb.SetPrimaryImageRequest{ImageId: "unknown"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	// the order is kept in the image metadata
+	_, err = laptopClient.SetPrimaryImage(context.Background(), &pb.SetPrimaryImageRequest{ImageId: imageIDs[1]})
+	require.NoError(t, err)
+
+	reloaded, err := service.NewDiskImageStore(imageFolder)
+	require.NoError(t, err)
+
+	primary, err := reloaded.Primary(laptop.GetId())
+	require.NoError(t, err)
+	require.Equal(t, imageIDs[1], primary.ID)
+
+	images, err := reloaded.List(laptop.GetId())
+	require.NoError(t, err)
+	require.Equal(t, imageIDs[0], images[1].ID)
+	require.Equal(t, imageIDs[2], images[2].ID)
+}
+
+func requireImageOrder(t *testing.T, expected []string, images []*pb.ImageMetadata) {
+	require.Len(t, images, len(expected))
+	for i, image := range images {
+		require.Equal(t, expected[i], image.GetImageId())
+	}
+}
+
+func requireSearchPrimaryImage(t *testing.T, laptopClient pb.LaptopServiceClient, laptopID string, primaryImageID string) {
+	stream, err := laptopClient.SearchLaptop(context.Background(), &pb.SearchLaptopRequest{Filter: &pb.Filter{MaxPriceUsd: 5000}})
+	require.NoError(t, err)
+
+	res, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, laptopID, res.GetLaptop().GetId())
+	require.Equal(t, primaryImageID, res.GetPrimaryImageId())
+
+	_, err = stream.Recv()
+	require.Equal(t, io.EOF, err)
+}
@@ -1,17 +1,23 @@
 package service
 
 import (
-	"bytes"
 	"context"
 	"errors"
+	"grpc_youtube_tutorial/memory"
 	"grpc_youtube_tutorial/pb"
+	"grpc_youtube_tutorial/validator"
 	"io"
 	"log"
+	"sort"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 // LaptopServer is a server that provides laptop services
@@ -19,14 +25,63 @@ type LaptopServer struct {
 	LaptopStore LaptopStore
 	ImageStore  ImageStore
 	RatingStore RatingStore
+	ReviewStore ReviewStore
+	// RatingHub publishes the ratings added to the RatingStore to WatchRatings
+	RatingHub *RatingHub
+	// RatingLimiter rejects ratings given too often, nil for no limits, which is the default
+	RatingLimiter *RatingLimiter
+	// RatingAuditStore records the ratings rejected by the RatingLimiter
+	RatingAuditStore RatingAuditStore
+
+	// ReadOnlySearch makes SearchLaptop send the stored encoding of each laptop
+	// instead of a copy, when the LaptopStore implements EncodedLaptopStore
+	ReadOnlySearch bool
+	// DownloadChunkSize is the size of the chunks DownloadImage sends
+	DownloadChunkSize int
+	// MaxImageSize is the largest image UploadImage accepts, in bytes
+	MaxImageSize int64
+	// MaxAllOrNothingLaptops is the number of laptops CreateLaptops holds until an all or nothing batch is sent
+	MaxAllOrNothingLaptops int
+	// RatingScale is the range of scores RateLaptop accepts
+	RatingScale RatingScale
+	// ReviewValidators check the reviews sent with ratings, any of them can reject a review
+	ReviewValidators []ReviewValidator
+
+	// ratingMutex keeps the ratings published in the order they're added
+	ratingMutex sync.Mutex
 }
 
+// Default chunk size for image downloads is 64 kb
+const defaultDownloadChunkSize = 64 << 10
+
+// Default maximum image size is 1 mb
+const defaultMaxImageSize = 1 << 20
+
+// Default maximum number of laptops in an all or nothing batch
+const defaultMaxAllOrNothingLaptops = 1000
+
+// Default number of laptops TopRatedLaptops sends
+const defaultTopRatedLimit = 10
+
+// Default number of entries ListRatingAudit sends
+const defaultRatingAuditLimit = 100
+
 // NewLaptopServer returns pointer to a LaptopServer
 func NewLaptopServer(laptopStore LaptopStore, imageStore ImageStore, ratingStore RatingStore) *LaptopServer {
 	return &LaptopServer{
-		LaptopStore: laptopStore,
-		ImageStore:  imageStore,
-		RatingStore: ratingStore,
+		LaptopStore:            laptopStore,
+		ImageStore:             imageStore,
+		RatingStore:            ratingStore,
+		ReviewStore:            NewInMemoryReviewStore(),
+		RatingHub:              NewRatingHub(),
+		RatingAuditStore:       NewInMemoryRatingAuditStore(),
+		DownloadChunkSize:      defaultDownloadChunkSize,
+		MaxImageSize:           defaultMaxImageSize,
+		MaxAllOrNothingLaptops: defaultMaxAllOrNothingLaptops,
+		RatingScale:            DefaultRatingScale,
+		ReviewValidators: []ReviewValidator{
+			ReviewLengthValidator(defaultMaxReviewTitleLength, defaultMaxReviewBodyLength),
+		},
 	}
 }
 
@@ -36,72 +91,390 @@ func (server *LaptopServer) CreateLaptop(ctx context.Context, req *pb.CreateLapt
 
 	log.Printf("recieved a create laptop request for laptop with id: %v", laptop.GetId())
 
+	err := prepareLaptop(laptop)
+	if err != nil {
+		return nil, err
+	}
+
+	// Heavy processing
+	// time.Sleep(6 * time.Second)
+
+	err = server.saveLaptop(ctx, laptop)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("saved laptop with id: %v", laptop.GetId())
+
+	return &pb.CreateLaptopResponse{
+		Id: laptop.GetId(),
+	}, nil
+}
+
+// CreateLaptops creates the laptops sent over the stream and reports a result for each of them
+func (server *LaptopServer) CreateLaptops(stream pb.LaptopService_CreateLaptopsServer) error {
+	var laptops []*pb.Laptop
+	allOrNothing := false
+	received := 0
+	res := &pb.CreateLaptopsResponse{}
+
+	for {
+		err := contextError(stream.Context())
+		if err != nil {
+			return err
+		}
+
+		req, err := stream.Recv()
+		if err == io.EOF {
+			log.Print("no more data")
+			break
+		}
+		if err != nil {
+			return status.Errorf(codes.Unknown, "unable to receive request stream: %v", err)
+		}
+
+		if received == 0 {
+			allOrNothing = req.GetAllOrNothing()
+		}
+		received++
+
+		laptop := req.GetLaptop()
+		log.Printf("recieved a create laptops request for laptop with id: %v", laptop.GetId())
+
+		if allOrNothing {
+			if len(laptops) >= server.MaxAllOrNothingLaptops {
+				return status.Errorf(codes.ResourceExhausted,
+					"too many laptops: maximum %d laptops created all or nothing", server.MaxAllOrNothingLaptops)
+			}
+
+			laptops = append(laptops, laptop)
+			continue
+		}
+
+		err = prepareLaptop(laptop)
+		if err == nil {
+			err = server.saveLaptop(stream.Context(), laptop)
+		}
+		res.Results = append(res.Results, newCreateLaptopResult(laptop, err))
+	}
+
+	if allOrNothing {
+		res.Results = server.saveAllLaptops(stream.Context(), laptops)
+	}
+
+	for _, result := range res.Results {
+		if codes.Code(result.GetCode()) == codes.OK {
+			res.CreatedCount++
+		} else {
+			res.FailedCount++
+		}
+	}
+
+	err := stream.SendAndClose(res)
+	if err != nil {
+		return status.Errorf(codes.Internal, "unable to send response: %v", err)
+	}
+
+	log.Printf("created %v laptops, %v failed", res.GetCreatedCount(), res.GetFailedCount())
+	return nil
+}
+
+// saveAllLaptops saves either all of the laptops or none of them, in a single store operation
+// so that no search sees part of the batch
+func (server *LaptopServer) saveAllLaptops(ctx context.Context, laptops []*pb.Laptop) []*pb.CreateLaptopResult {
+	results := make([]*pb.CreateLaptopResult, len(laptops))
+	failed := false
+
+	for i, laptop := range laptops {
+		err := prepareLaptop(laptop)
+		results[i] = newCreateLaptopResult(laptop, err)
+		failed = failed || err != nil
+	}
+
+	if !failed {
+		err := contextError(ctx)
+		if err != nil {
+			return newCreateLaptopResults(laptops, err)
+		}
+
+		err = server.LaptopStore.SaveAll(laptops)
+		if err == nil {
+			return results
+		}
+
+		var saveAllErr *SaveAllError
+		if !errors.As(err, &saveAllErr) {
+			return newCreateLaptopResults(laptops, saveLaptopError(err))
+		}
+
+		laptop := laptops[saveAllErr.Index]
+		results[saveAllErr.Index] = newCreateLaptopResult(laptop, saveLaptopError(saveAllErr.Err))
+	}
+
+	for i, laptop := range laptops {
+		if results[i].GetCode() == int32(codes.OK) {
+			err := status.Error(codes.Aborted, "laptop not created: another laptop in the batch failed")
+			results[i] = newCreateLaptopResult(laptop, err)
+		}
+	}
+
+	return results
+}
+
+// prepareLaptop checks the laptop ID, or generates one if it's missing, validates the laptop
+// and normalizes its memories
+func prepareLaptop(laptop *pb.Laptop) error {
+	if laptop == nil {
+		return status.Error(codes.InvalidArgument, "laptop is missing")
+	}
+
 	if len(laptop.GetId()) > 0 {
 		_, err := uuid.Parse(laptop.GetId())
 		if err != nil {
-			return nil, status.Errorf(codes.InvalidArgument, "laptop id is invalid: %v", err)
+			return status.Errorf(codes.InvalidArgument, "laptop id is invalid: %v", err)
 		}
 	} else {
 		id, err := uuid.NewRandom()
 		if err != nil {
-			return nil, status.Error(codes.Internal, "cannot generate new ID for laptop")
+			return status.Error(codes.Internal, "cannot generate new ID for laptop")
 		}
 		laptop.Id = id.String()
 	}
 
-	// Heavy processing
-	// time.Sleep(6 * time.Second)
+	err := validator.ValidateLaptop(laptop)
+	if err != nil {
+		return err
+	}
+
+	return normalizeLaptopMemory(laptop)
+}
+
+// normalizeLaptopMemory stores the RAM, GPU memory and storage of the laptop in memory.CanonicalUnit
+func normalizeLaptopMemory(laptop *pb.Laptop) error {
+	ram, err := memory.Normalize(laptop.GetRam())
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "laptop ram is invalid: %v", err)
+	}
+	laptop.Ram = ram
+
+	for i, gpu := range laptop.GetGpus() {
+		gpuMemory, err := memory.Normalize(gpu.GetMemory())
+		if err != nil {
+			return status.Errorf(codes.InvalidArgument, "laptop gpu %d memory is invalid: %v", i, err)
+		}
+		gpu.Memory = gpuMemory
+	}
 
+	for i, storage := range laptop.GetStorages() {
+		storageMemory, err := memory.Normalize(storage.GetMemory())
+		if err != nil {
+			return status.Errorf(codes.InvalidArgument, "laptop storage %d memory is invalid: %v", i, err)
+		}
+		storage.Memory = storageMemory
+	}
+
+	return nil
+}
+
+// saveLaptop saves the laptop to the store unless the request is done
+func (server *LaptopServer) saveLaptop(ctx context.Context, laptop *pb.Laptop) error {
 	// Don't save if request cancelled
 	if ctx.Err() == context.Canceled {
 		log.Print("request cancelled")
-		return nil, status.Errorf(codes.Canceled, "request cancelled")
+		return status.Errorf(codes.Canceled, "request cancelled")
 	}
 
 	// Don't save if deadline exceeded
 	if ctx.Err() == context.DeadlineExceeded {
 		log.Print("deadline exceeded")
-		return nil, status.Errorf(codes.DeadlineExceeded, "deadline exeeded")
+		return status.Errorf(codes.DeadlineExceeded, "deadline exeeded")
 	}
 
-	err := server.LaptopStore.Save(laptop)
+	return saveLaptopError(server.LaptopStore.Save(laptop))
+}
+
+// saveLaptopError returns the status of an error saving a laptop to the store
+func saveLaptopError(err error) error {
 	if errors.Is(err, ErrAlreadyExists) {
-		return nil, status.Errorf(codes.AlreadyExists, "unable to save data: %v", err)
+		return status.Errorf(codes.AlreadyExists, "unable to save data: %v", err)
 	} else if err != nil {
-		return nil, status.Errorf(codes.Internal, "unable to save data: %v", err)
+		return status.Errorf(codes.Internal, "unable to save data: %v", err)
 	}
 
-	log.Printf("saved laptop with id: %v", laptop.GetId())
+	return nil
+}
 
-	return &pb.CreateLaptopResponse{
-		Id: laptop.GetId(),
-	}, nil
+// newCreateLaptopResults returns the same result for all of the laptops
+func newCreateLaptopResults(laptops []*pb.Laptop, err error) []*pb.CreateLaptopResult {
+	results := make([]*pb.CreateLaptopResult, len(laptops))
+	for i, laptop := range laptops {
+		results[i] = newCreateLaptopResult(laptop, err)
+	}
+	return results
+}
+
+// newCreateLaptopResult returns the result of creating the laptop, with the field violations of a validation error
+func newCreateLaptopResult(laptop *pb.Laptop, err error) *pb.CreateLaptopResult {
+	st := status.Convert(err)
+
+	result := &pb.CreateLaptopResult{
+		Id:      laptop.GetId(),
+		Code:    int32(st.Code()),
+		Message: st.Message(),
+	}
+
+	for _, detail := range st.Details() {
+		badRequest, ok := detail.(*errdetails.BadRequest)
+		if !ok {
+			continue
+		}
+
+		for _, violation := range badRequest.GetFieldViolations() {
+			result.FieldViolations = append(result.FieldViolations, &pb.CreateLaptopResult_FieldViolation{
+				Field:       violation.GetField(),
+				Description: violation.GetDescription(),
+			})
+		}
+	}
+
+	return result
 }
 
 // SearchLaptop returns a laptop based on filter
 func (server *LaptopServer) SearchLaptop(req *pb.SearchLaptopRequest, stream pb.LaptopService_SearchLaptopServer) error {
 	filter := req.GetFilter()
-	log.Printf("received a search laptop request with: %v", filter)
+	log.Printf("received a search laptop request with: %v, sorted by: %v", filter, req.GetSortBy())
 
-	err := server.LaptopStore.Search(filter,
-		func(laptop *pb.Laptop) error {
-			res := &pb.SearchLaptopResponse{Laptop: laptop}
+	if _, ok := pb.SearchLaptopRequest_SortBy_name[int32(req.GetSortBy())]; !ok {
+		return status.Errorf(codes.InvalidArgument, "cannot sort laptops by %v", req.GetSortBy())
+	}
 
-			err := stream.Send(res)
-			if err != nil {
-				return err
-			}
+	send := func(result *searchResult) error {
+		err := result.send()
+		if err != nil {
+			return err
+		}
+
+		log.Printf("sent laptop with id: %s", result.laptopID)
+		return nil
+	}
 
-			log.Printf("sent laptop with id: %s", laptop.GetId())
+	// sorted results are sent once they're all found
+	var results []*searchResult
+	if req.GetSortBy() != pb.SearchLaptopRequest_UNKNOWN {
+		send = func(result *searchResult) error {
+			results = append(results, result)
 			return nil
-		},
-	)
+		}
+	}
+
+	var err error
+	if encodedStore, ok := server.LaptopStore.(EncodedLaptopStore); ok && server.ReadOnlySearch {
+		err = encodedStore.SearchEncoded(filter,
+			func(laptopID string, data []byte) error {
+				res := newEncodedSearchLaptopResponse(data, server.primaryImageID(laptopID))
+
+				return send(&searchResult{
+					laptopID: laptopID,
+					send:     func() error { return stream.SendMsg(res) },
+				})
+			},
+		)
+	} else {
+		err = server.LaptopStore.Search(filter,
+			func(laptop *pb.Laptop) error {
+				res := &pb.SearchLaptopResponse{
+					Laptop:         laptop,
+					PrimaryImageId: server.primaryImageID(laptop.GetId()),
+				}
+
+				return send(&searchResult{
+					laptopID: laptop.GetId(),
+					send:     func() error { return stream.Send(res) },
+				})
+			},
+		)
+	}
+	if err != nil {
+		return err
+	}
+
+	if results == nil {
+		return nil
+	}
+
+	err = server.sortSearchResults(results, req.GetSortBy())
 	if err != nil {
 		return err
 	}
+
+	for _, result := range results {
+		err := result.send()
+		if err != nil {
+			return err
+		}
+
+		log.Printf("sent laptop with id: %s", result.laptopID)
+	}
+
+	return nil
+}
+
+// searchResult is a laptop found by SearchLaptop, send sends the response for it
+type searchResult struct {
+	laptopID string
+	send     func() error
+	score    float64
+	rated    bool
+}
+
+// sortSearchResults sorts the results by the rating score of sortBy, best first, unrated laptops last
+func (server *LaptopServer) sortSearchResults(results []*searchResult, sortBy pb.SearchLaptopRequest_SortBy) error {
+	for _, result := range results {
+		rating, err := server.RatingStore.Find(result.laptopID)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		} else if err != nil {
+			return status.Errorf(codes.Internal, "cannot find rating: %v", err)
+		}
+
+		result.rated = true
+		switch sortBy {
+		case pb.SearchLaptopRequest_AVERAGE_SCORE:
+			result.score = rating.Mean()
+		case pb.SearchLaptopRequest_RECENT_AVERAGE_SCORE:
+			result.score = rating.RecentMean()
+		case pb.SearchLaptopRequest_DECAYED_SCORE:
+			result.score = rating.DecayedScore
+		default:
+			return status.Errorf(codes.InvalidArgument, "cannot sort laptops by %v", sortBy)
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].rated != results[j].rated {
+			return results[i].rated
+		}
+		return results[i].score > results[j].score
+	})
+
 	return nil
 }
 
+// primaryImageID returns the Id of the primary image of a laptop, or an empty string if it has none
+func (server *LaptopServer) primaryImageID(laptopID string) string {
+	if server.ImageStore == nil {
+		return ""
+	}
+
+	info, err := server.ImageStore.Primary(laptopID)
+	if err != nil {
+		return ""
+	}
+
+	return info.ID
+}
+
 func contextError(ctx context.Context) error {
 	switch ctx.Err() {
 	case context.Canceled:
@@ -123,6 +496,10 @@ func (server *LaptopServer) UploadImage(stream pb.LaptopService_UploadImageServe
 		return status.Error(codes.Unknown, "cannot recieve image info")
 	}
 
+	if len(req.GetSessionId()) > 0 {
+		return server.uploadImageSession(stream, req)
+	}
+
 	laptopID := req.GetInfo().GetLaptopId()
 	imageType := req.GetInfo().GetImageType()
 
@@ -136,8 +513,18 @@ func (server *LaptopServer) UploadImage(stream pb.LaptopService_UploadImageServe
 		return status.Errorf(codes.InvalidArgument, "laptop %v doesn't exist", laptopID)
 	}
 
-	imageData := bytes.Buffer{}
-	imageSize := 0
+	uploader := uploaderFromContext(stream.Context())
+
+	writer, err := server.ImageStore.Create(laptopID, imageType, uploader)
+	if errors.Is(err, ErrInvalidImage) {
+		return status.Errorf(codes.InvalidArgument, "cannot create image in the store: %v", err)
+	} else if err != nil {
+		return status.Errorf(codes.Internal, "cannot create image in the store: %v", err)
+	}
+	// the written image is discarded unless it's committed
+	defer writer.Abort()
+
+	imageSize := int64(0)
 
 	for {
 		// Check error
@@ -160,26 +547,30 @@ func (server *LaptopServer) UploadImage(stream pb.LaptopService_UploadImageServe
 
 		//  time.Sleep(time.Second)
 
-		imageSize += size
+		imageSize += int64(size)
 
-		if imageSize > maxImageSize {
-			return status.Error(codes.InvalidArgument, "image size too big: maximum 1mb")
+		if imageSize > server.MaxImageSize {
+			return status.Errorf(codes.InvalidArgument, "image size too big: maximum %d bytes", server.MaxImageSize)
 		}
 
-		_, err = imageData.Write(chunk)
+		_, err = writer.Write(chunk)
 		if err != nil {
 			return status.Errorf(codes.Internal, "cannot write chunk to data: %v", err)
 		}
 	}
 
-	imageID, err := server.ImageStore.Save(laptopID, imageType, imageData)
+	info, err := writer.Commit()
 	if err != nil {
-		return status.Errorf(codes.Internal, "cannot save image to the store: %v", err)
+		return saveImageError(err)
 	}
+	imageID := info.ID
 
 	res := &pb.UploadImageResponse{
-		Id:   imageID,
-		Size: strconv.Itoa(imageSize),
+		Id:       imageID,
+		Size:     strconv.FormatInt(info.Size, 10),
+		Width:    uint32(info.Width),
+		Height:   uint32(info.Height),
+		Checksum: info.Checksum,
 	}
 
 	err = stream.SendAndClose(res)
@@ -187,53 +578,993 @@ func (server *LaptopServer) UploadImage(stream pb.LaptopService_UploadImageServe
 		return status.Errorf(codes.Internal, "unable to send response: %v", err)
 	}
 
-	log.Printf("image successfully saved with ID: %v and Size: %v", imageID, imageSize)
+	log.Printf("image successfully saved with ID: %v and Size: %v", imageID, info.Size)
 	return nil
 }
 
-// RateLaptop service allows user to rate laptops
-func (server *LaptopServer) RateLaptop(stream pb.LaptopService_RateLaptopServer) error {
-	for {
-		err := contextError(stream.Context())
-		if err != nil {
-			return err
-		}
+// saveImageError converts an error from storing an uploaded image to a status error
+func saveImageError(err error) error {
+	if errors.Is(err, ErrInvalidImage) {
+		return status.Errorf(codes.InvalidArgument, "cannot save image to the store: %v", err)
+	}
+	return status.Errorf(codes.Internal, "cannot save image to the store: %v", err)
+}
 
-		req, err := stream.Recv()
-		if err == io.EOF {
-			log.Print("no more data")
-			break
-		}
+// StartImageUpload service starts a resumable image upload
+func (server *LaptopServer) StartImageUpload(ctx context.Context, req *pb.StartImageUploadRequest) (*pb.StartImageUploadResponse, error) {
+	laptopID := req.GetInfo().GetLaptopId()
+	imageType := req.GetInfo().GetImageType()
+	size := req.GetSize()
 
-		if err != nil {
-			return status.Errorf(codes.Unknown, "unable to receive request stream: %v", err)
-		}
+	log.Printf("recieved a start image upload request with laptop ID %v, image type %v and size %v", laptopID, imageType, size)
+
+	_, found := server.LaptopStore.Find(laptopID)
+	if !found {
+		return nil, status.Errorf(codes.NotFound, "laptop with id, %v, not found", laptopID)
+	}
 
-		laptopID := req.GetLaptopId()
-		score := req.GetScore()
+	if size <= 0 || size > server.MaxImageSize {
+		return nil, status.Errorf(codes.InvalidArgument, "image size must be between 1 and %d bytes", server.MaxImageSize)
+	}
 
-		log.Printf("recieved a rate laptop request: laptop id %v, score: %v", laptopID, score)
+	uploader := uploaderFromContext(ctx)
 
-		_, found := server.LaptopStore.Find(laptopID)
-		if !found {
-			return status.Errorf(codes.NotFound, "laptop with id, %v, not found", laptopID)
+	session, err := server.ImageStore.StartUpload(laptopID, imageType, uploader, size)
+	if errors.Is(err, ErrInvalidImage) {
+		return nil, status.Errorf(codes.InvalidArgument, "cannot start image upload: %v", err)
+	} else if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot start image upload: %v", err)
+	}
+
+	log.Printf("started image upload session with ID: %v", session.ID)
+
+	return &pb.StartImageUploadResponse{
+		SessionId: session.ID,
+		ExpiresAt: timestamppb.New(session.ExpiresAt),
+	}, nil
+}
+
+// GetUploadStatus service returns the number of bytes received by a resumable image upload
+func (server *LaptopServer) GetUploadStatus(ctx context.Context, req *pb.GetUploadStatusRequest) (*pb.GetUploadStatusResponse, error) {
+	session, err := server.ImageStore.FindUpload(req.GetSessionId())
+	if errors.Is(err, ErrNotFound) {
+		return nil, status.Errorf(codes.NotFound, "upload session with id, %v, not found", req.GetSessionId())
+	} else if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot find upload session: %v", err)
+	}
+
+	if session.Uploader != uploaderFromContext(ctx) {
+		return nil, status.Errorf(codes.PermissionDenied, "upload session with id, %v, belongs to another user", req.GetSessionId())
+	}
+
+	return &pb.GetUploadStatusResponse{
+		SessionId:     session.ID,
+		LaptopId:      session.LaptopID,
+		Size:          session.Size,
+		ReceivedBytes: session.Received,
+		ExpiresAt:     timestamppb.New(session.ExpiresAt),
+	}, nil
+}
+
+// uploaderFromContext returns the username of the user uploading an image, or an empty string without authentication
+func uploaderFromContext(ctx context.Context) string {
+	claims, ok := ClaimsFromContext(ctx)
+	if !ok {
+		return ""
+	}
+	return claims.Username
+}
+
+// uploadImageSession writes the chunks of a resumable upload, starting with the already received req,
+// and stores the image once all of its bytes are received
+func (server *LaptopServer) uploadImageSession(stream pb.LaptopService_UploadImageServer, req *pb.UploadImageRequest) error {
+	sessionID := req.GetSessionId()
+
+	log.Printf("recieved a upload image request for session ID %v at offset %v", sessionID, req.GetOffset())
+
+	session, err := server.ImageStore.FindUpload(sessionID)
+	if errors.Is(err, ErrNotFound) {
+		return status.Errorf(codes.NotFound, "upload session with id, %v, not found", sessionID)
+	} else if err != nil {
+		return status.Errorf(codes.Internal, "cannot find upload session: %v", err)
+	}
+
+	if session.Uploader != uploaderFromContext(stream.Context()) {
+		return status.Errorf(codes.PermissionDenied, "upload session with id, %v, belongs to another user", sessionID)
+	}
+
+	for {
+		if req.GetSessionId() != sessionID {
+			return status.Errorf(codes.InvalidArgument, "chunk for session %v sent to session %v", req.GetSessionId(), sessionID)
 		}
 
-		rating, err := server.RatingStore.Add(laptopID, score)
-		if err != nil {
-			return status.Errorf(codes.Internal, "unable to add rating to rating store: %v", err)
+		session, err = server.ImageStore.WriteUpload(sessionID, req.GetOffset(), req.GetChunk())
+		if errors.Is(err, ErrNotFound) {
+			return status.Errorf(codes.NotFound, "upload session with id, %v, not found", sessionID)
+		} else if errors.Is(err, ErrUploadOffset) {
+			return status.Errorf(codes.OutOfRange, "chunk offset %v doesn't match the received bytes", req.GetOffset())
+		} else if err != nil {
+			return status.Errorf(codes.Internal, "cannot write chunk: %v", err)
 		}
 
-		res := &pb.RateLaptopResponse{
-			LaptopId:     laptopID,
-			RatedCount:   rating.Count,
-			AverageScore: rating.Sum / float64(rating.Count),
+		if session.Received == session.Size {
+			break
 		}
 
-		err = stream.Send(res)
+		if err := contextError(stream.Context()); err != nil {
+			return err
+		}
+
+		req, err = stream.Recv()
+		if err == io.EOF {
+			return status.Errorf(codes.FailedPrecondition, "upload is incomplete: received %v of %v bytes", session.Received, session.Size)
+		}
 		if err != nil {
-			return status.Errorf(codes.Internal, "unable to send response: %v", err)
+			return status.Errorf(codes.Unknown, "cannot recieve chunk: %v", err)
 		}
 	}
+
+	info, err := server.ImageStore.CompleteUpload(sessionID)
+	if err != nil {
+		return saveImageError(err)
+	}
+
+	res := &pb.UploadImageResponse{
+		Id:       info.ID,
+		Size:     strconv.FormatInt(info.Size, 10),
+		Width:    uint32(info.Width),
+		Height:   uint32(info.Height),
+		Checksum: info.Checksum,
+	}
+
+	err = stream.SendAndClose(res)
+	if err != nil {
+		return status.Errorf(codes.Internal, "unable to send response: %v", err)
+	}
+
+	log.Printf("image successfully saved with ID: %v and Size: %v", info.ID, info.Size)
 	return nil
 }
+
+// DownloadImage service sends the information of an image followed by its content in chunks
+func (server *LaptopServer) DownloadImage(req *pb.DownloadImageRequest, stream pb.LaptopService_DownloadImageServer) error {
+	imageID := req.GetImageId()
+	offset := req.GetOffset()
+	thumbnailSize := int(req.GetThumbnailSize())
+
+	log.Printf("recieved a download image request with image ID %v, offset %v and thumbnail size %v", imageID, offset, thumbnailSize)
+
+	info, err := server.ImageStore.Find(imageID)
+	if errors.Is(err, ErrNotFound) {
+		return status.Errorf(codes.NotFound, "image with id, %v, not found", imageID)
+	} else if err != nil {
+		return status.Errorf(codes.Internal, "cannot find image: %v", err)
+	}
+
+	metadata := newImageMetadata(info)
+	var thumbnail *ThumbnailInfo
+
+	if thumbnailSize > 0 {
+		var ok bool
+		thumbnail, ok = info.Thumbnail(thumbnailSize)
+		if ok {
+			metadata = newThumbnailMetadata(info, thumbnail)
+		} else if info.Width > thumbnailSize || info.Height > thumbnailSize {
+			return status.Errorf(codes.NotFound, "image with id, %v, has no thumbnail of size %v", imageID, thumbnailSize)
+		}
+	}
+
+	if offset < 0 || offset > metadata.GetSize() {
+		return status.Errorf(codes.OutOfRange, "offset %v is outside of the image size %v", offset, metadata.GetSize())
+	}
+
+	var image ImageReader
+	if thumbnail != nil {
+		image, err = server.ImageStore.OpenThumbnail(imageID, thumbnailSize)
+	} else {
+		image, err = server.ImageStore.Open(imageID)
+	}
+	if err != nil {
+		return status.Errorf(codes.Internal, "cannot open image: %v", err)
+	}
+	defer image.Close()
+
+	_, err = image.Seek(offset, io.SeekStart)
+	if err != nil {
+		return status.Errorf(codes.Internal, "cannot seek image: %v", err)
+	}
+
+	res := &pb.DownloadImageResponse{
+		Data: &pb.DownloadImageResponse_Info{
+			Info: metadata,
+		},
+	}
+
+	err = stream.Send(res)
+	if err != nil {
+		return status.Errorf(codes.Unknown, "cannot send image info: %v", err)
+	}
+
+	chunkSize := server.DownloadChunkSize
+	if chunkSize <= 0 {
+		chunkSize = defaultDownloadChunkSize
+	}
+
+	buffer := make([]byte, chunkSize)
+	for {
+		if err := contextError(stream.Context()); err != nil {
+			return err
+		}
+
+		n, err := image.Read(buffer)
+		if n > 0 {
+			res := &pb.DownloadImageResponse{
+				Data: &pb.DownloadImageResponse_Chunk{
+					Chunk: buffer[:n],
+				},
+			}
+
+			err := stream.Send(res)
+			if err != nil {
+				return status.Errorf(codes.Unknown, "cannot send chunk: %v", err)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return status.Errorf(codes.Internal, "cannot read image: %v", err)
+		}
+	}
+
+	log.Printf("image with ID %v successfully sent", imageID)
+	return nil
+}
+
+// ListImages service returns the information of all images of a laptop
+func (server *LaptopServer) ListImages(ctx context.Context, req *pb.ListImagesRequest) (*pb.ListImagesResponse, error) {
+	laptopID := req.GetLaptopId()
+
+	log.Printf("recieved a list images request with laptop ID %v", laptopID)
+
+	_, found := server.LaptopStore.Find(laptopID)
+	if !found {
+		return nil, status.Errorf(codes.NotFound, "laptop with id, %v, not found", laptopID)
+	}
+
+	images, err := server.listImageMetadata(laptopID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.ListImagesResponse{Images: images}, nil
+}
+
+// DeleteImage service removes an image from the image store
+func (server *LaptopServer) DeleteImage(ctx context.Context, req *pb.DeleteImageRequest) (*pb.DeleteImageResponse, error) {
+	imageID := req.GetImageId()
+
+	log.Printf("recieved a delete image request with image ID %v", imageID)
+
+	err := server.ImageStore.Delete(imageID)
+	if errors.Is(err, ErrNotFound) {
+		return nil, status.Errorf(codes.NotFound, "image with id, %v, not found", imageID)
+	} else if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot delete image: %v", err)
+	}
+
+	log.Printf("deleted image with ID: %v", imageID)
+
+	return &pb.DeleteImageResponse{}, nil
+}
+
+// SetPrimaryImage service makes an image the first image of its laptop
+func (server *LaptopServer) SetPrimaryImage(ctx context.Context, req *pb.SetPrimaryImageRequest) (*pb.SetPrimaryImageResponse, error) {
+	imageID := req.GetImageId()
+
+	log.Printf("recieved a set primary image request with image ID %v", imageID)
+
+	info, err := server.ImageStore.Find(imageID)
+	if err == nil {
+		err = server.ImageStore.SetPrimary(imageID)
+	}
+	if errors.Is(err, ErrNotFound) {
+		return nil, status.Errorf(codes.NotFound, "image with id, %v, not found", imageID)
+	} else if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot set primary image: %v", err)
+	}
+
+	images, err := server.listImageMetadata(info.LaptopID)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("image with ID %v is the primary image of laptop %v", imageID, info.LaptopID)
+
+	return &pb.SetPrimaryImageResponse{Images: images}, nil
+}
+
+// ReorderImages service sets the order of the images of a laptop
+func (server *LaptopServer) ReorderImages(ctx context.Context, req *pb.ReorderImagesRequest) (*pb.ReorderImagesResponse, error) {
+	laptopID := req.GetLaptopId()
+
+	log.Printf("recieved a reorder images request with laptop ID %v", laptopID)
+
+	err := server.ImageStore.Reorder(laptopID, req.GetImageIds())
+	if errors.Is(err, ErrNotFound) {
+		return nil, status.Errorf(codes.NotFound, "laptop with id, %v, has no images", laptopID)
+	} else if errors.Is(err, ErrInvalidImageOrder) {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	} else if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot reorder images: %v", err)
+	}
+
+	images, err := server.listImageMetadata(laptopID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.ReorderImagesResponse{Images: images}, nil
+}
+
+func (server *LaptopServer) listImageMetadata(laptopID string) ([]*pb.ImageMetadata, error) {
+	images, err := server.ImageStore.List(laptopID)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot list images: %v", err)
+	}
+
+	metadata := make([]*pb.ImageMetadata, 0, len(images))
+	for _, info := range images {
+		metadata = append(metadata, newImageMetadata(info))
+	}
+
+	return metadata, nil
+}
+
+func newImageMetadata(info *ImageInfo) *pb.ImageMetadata {
+	return &pb.ImageMetadata{
+		ImageId:   info.ID,
+		LaptopId:  info.LaptopID,
+		ImageType: info.Type,
+		Size:      info.Size,
+		Checksum:  info.Checksum,
+		Width:     uint32(info.Width),
+		Height:    uint32(info.Height),
+		Uploader:  info.Uploader,
+		CreatedAt: timestamppb.New(info.CreatedAt),
+
+		ThumbnailSizes: thumbnailSizes(info),
+	}
+}
+
+// newThumbnailMetadata returns the metadata of an image, describing its thumbnail's content
+func newThumbnailMetadata(info *ImageInfo, thumbnail *ThumbnailInfo) *pb.ImageMetadata {
+	metadata := newImageMetadata(info)
+	metadata.Size = thumbnail.FileSize
+	metadata.Checksum = thumbnail.Checksum
+	metadata.Width = uint32(thumbnail.Width)
+	metadata.Height = uint32(thumbnail.Height)
+	metadata.ThumbnailSize = uint32(thumbnail.Size)
+	return metadata
+}
+
+func thumbnailSizes(info *ImageInfo) []uint32 {
+	sizes := make([]uint32, 0, len(info.Thumbnails))
+	for _, thumbnail := range info.Thumbnails {
+		sizes = append(sizes, uint32(thumbnail.Size))
+	}
+	return sizes
+}
+
+// RateLaptop service allows user to rate laptops
+func (server *LaptopServer) RateLaptop(stream pb.LaptopService_RateLaptopServer) error {
+	// ratings are kept per user, so a user rating a laptop again replaces their score
+	claims, ok := ClaimsFromContext(stream.Context())
+	if !ok {
+		return status.Errorf(codes.Unauthenticated, "rating a laptop requires an authenticated user")
+	}
+
+	for {
+		err := contextError(stream.Context())
+		if err != nil {
+			return err
+		}
+
+		req, err := stream.Recv()
+		if err == io.EOF {
+			log.Print("no more data")
+			break
+		}
+
+		if err != nil {
+			return status.Errorf(codes.Unknown, "unable to receive request stream: %v", err)
+		}
+
+		res, err := server.rateLaptop(claims, req)
+		if err != nil {
+			return err
+		}
+
+		err = stream.Send(res)
+		if err != nil {
+			return status.Errorf(codes.Internal, "unable to send response: %v", err)
+		}
+	}
+	return nil
+}
+
+// rateLaptop saves the score, and the review if any, of a rate laptop request.
+// A rejected rating gets an error response, so that the client can go on rating other laptops,
+// while the returned error ends the stream.
+func (server *LaptopServer) rateLaptop(claims *UserClaims, req *pb.RateLaptopRequest) (*pb.RateLaptopResponse, error) {
+	laptopID := req.GetLaptopId()
+	score := req.GetScore()
+	username := claims.Username
+
+	log.Printf("recieved a rate laptop request: laptop id %v, score: %v, user: %v", laptopID, score, username)
+
+	err := server.RatingScale.Validate(score)
+	if err != nil {
+		return newRateLaptopError(laptopID, status.Error(codes.InvalidArgument, err.Error())), nil
+	}
+
+	var review *Review
+	if len(req.GetReviewTitle()) > 0 || len(req.GetReviewBody()) > 0 {
+		review = &Review{
+			LaptopID: laptopID,
+			Username: username,
+			Score:    score,
+			Title:    req.GetReviewTitle(),
+			Body:     req.GetReviewBody(),
+		}
+
+		err = server.validateReview(review)
+		if err != nil {
+			return newRateLaptopError(laptopID, status.Error(codes.InvalidArgument, err.Error())), nil
+		}
+	}
+
+	_, found := server.LaptopStore.Find(laptopID)
+	if !found {
+		return newRateLaptopError(laptopID, status.Errorf(codes.NotFound, "laptop with id, %v, not found", laptopID)), nil
+	}
+
+	err = server.limitRating(claims, laptopID)
+	if errors.Is(err, ErrRatingLimited) {
+		return newRateLaptopError(laptopID, status.Error(codes.ResourceExhausted, err.Error())), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if review != nil {
+		return server.saveReview(review)
+	}
+
+	rating, updated, err := server.addRating(laptopID, username, score)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "unable to add rating to rating store: %v", err)
+	}
+
+	res := newRateLaptopResponse(laptopID, rating)
+	res.Updated = updated
+
+	return res, nil
+}
+
+// limitRating checks the rating of a laptop by the user against the rating limits,
+// recording the rejected ratings in the audit store
+func (server *LaptopServer) limitRating(claims *UserClaims, laptopID string) error {
+	if server.RatingLimiter == nil {
+		return nil
+	}
+
+	// tokens without the creation time of the account are of accounts of unknown age
+	var accountCreatedAt time.Time
+	if claims.CreatedAt != 0 {
+		accountCreatedAt = time.Unix(claims.CreatedAt, 0)
+	}
+
+	err := server.RatingLimiter.Allow(claims.Username, accountCreatedAt, laptopID)
+	if err == nil {
+		return nil
+	}
+
+	log.Printf("rejected rating of laptop %v by user %v: %v", laptopID, claims.Username, err)
+
+	auditErr := server.RatingAuditStore.Record(&RatingAuditEntry{
+		Username:   claims.Username,
+		LaptopID:   laptopID,
+		Reason:     err.Error(),
+		RecordedAt: time.Now(),
+	})
+	if auditErr != nil {
+		return status.Errorf(codes.Internal, "cannot record rejected rating: %v", auditErr)
+	}
+
+	return err
+}
+
+// addRating adds the score of the user to the rating of the laptop and publishes the new rating
+func (server *LaptopServer) addRating(laptopID string, username string, score float64) (*Rating, bool, error) {
+	server.ratingMutex.Lock()
+	defer server.ratingMutex.Unlock()
+
+	rating, updated, err := server.RatingStore.Add(laptopID, username, score)
+	if err != nil {
+		return nil, false, err
+	}
+
+	server.RatingHub.Publish(laptopID, rating)
+
+	return rating, updated, nil
+}
+
+// addReviewRating adds the score of an approved review to the rating of the laptop, as given when the review was written,
+// and publishes the new rating, unless the user rated the laptop since
+func (server *LaptopServer) addReviewRating(review *Review) error {
+	server.ratingMutex.Lock()
+	defer server.ratingMutex.Unlock()
+
+	ratedAt, err := server.RatingStore.RatedAt(review.LaptopID, review.Username)
+	if err == nil && ratedAt.After(review.UpdatedAt) {
+		return nil
+	} else if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	rating, _, err := server.RatingStore.AddAt(review.LaptopID, review.Username, review.Score, review.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	server.RatingHub.Publish(review.LaptopID, rating)
+
+	return nil
+}
+
+// removeReviewRating removes the score of the user who wrote the review from the rating of the laptop
+// and publishes the new rating, unless the user rated the laptop since writing the review
+func (server *LaptopServer) removeReviewRating(review *Review) error {
+	server.ratingMutex.Lock()
+	defer server.ratingMutex.Unlock()
+
+	ratedAt, err := server.RatingStore.RatedAt(review.LaptopID, review.Username)
+	if errors.Is(err, ErrNotFound) || (err == nil && ratedAt.After(review.UpdatedAt)) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	rating, err := server.RatingStore.Remove(review.LaptopID, review.Username)
+	if err != nil {
+		return err
+	}
+
+	server.RatingHub.Publish(review.LaptopID, rating)
+
+	return nil
+}
+
+// saveReview saves a review pending moderation, its score is only added to the rating once it's approved
+func (server *LaptopServer) saveReview(review *Review) (*pb.RateLaptopResponse, error) {
+	review, replaced, err := server.ReviewStore.Save(review)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "unable to save review: %v", err)
+	}
+
+	// the score of the approved review being replaced no longer counts, the new one waits for moderation
+	if replaced != nil && replaced.Status == ReviewApproved {
+		err = server.removeReviewRating(replaced)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "unable to remove rating from rating store: %v", err)
+		}
+	}
+
+	rating, err := server.RatingStore.Find(review.LaptopID)
+	if errors.Is(err, ErrNotFound) {
+		// the laptop isn't rated yet
+		rating = &Rating{}
+	} else if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot find rating: %v", err)
+	}
+
+	res := newRateLaptopResponse(review.LaptopID, rating)
+	res.ReviewId = review.ID
+	res.ReviewStatus = pb.Review_PENDING
+
+	return res, nil
+}
+
+// validateReview runs the review validators, returning the error of the first one rejecting the review
+func (server *LaptopServer) validateReview(review *Review) error {
+	for _, validate := range server.ReviewValidators {
+		err := validate(review)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newRateLaptopResponse returns the response with the rating of a laptop
+func newRateLaptopResponse(laptopID string, rating *Rating) *pb.RateLaptopResponse {
+	return &pb.RateLaptopResponse{
+		LaptopId:           laptopID,
+		RatedCount:         rating.Count,
+		AverageScore:       rating.Mean(),
+		RecentRatedCount:   rating.RecentCount,
+		RecentAverageScore: rating.RecentMean(),
+		DecayedScore:       rating.DecayedScore,
+	}
+}
+
+// newRateLaptopError returns the response to a rating that failed with err
+func newRateLaptopError(laptopID string, err error) *pb.RateLaptopResponse {
+	st := status.Convert(err)
+
+	return &pb.RateLaptopResponse{
+		LaptopId: laptopID,
+		Code:     int32(st.Code()),
+		Message:  st.Message(),
+	}
+}
+
+// GetRating service returns the rating statistics of a laptop
+func (server *LaptopServer) GetRating(ctx context.Context, req *pb.GetRatingRequest) (*pb.GetRatingResponse, error) {
+	laptopID := req.GetLaptopId()
+
+	log.Printf("recieved a get rating request with laptop ID %v", laptopID)
+
+	_, found := server.LaptopStore.Find(laptopID)
+	if !found {
+		return nil, status.Errorf(codes.NotFound, "laptop with id, %v, not found", laptopID)
+	}
+
+	rating, err := server.RatingStore.Find(laptopID)
+	if errors.Is(err, ErrNotFound) {
+		// the laptop isn't rated yet
+		return &pb.GetRatingResponse{LaptopId: laptopID}, nil
+	} else if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot find rating: %v", err)
+	}
+
+	res := &pb.GetRatingResponse{
+		LaptopId:     laptopID,
+		RatedCount:   rating.Count,
+		AverageScore: rating.Mean(),
+		MedianScore:  rating.Percentile(0.5),
+		ScoreStdDev:  rating.StdDev(),
+		P25Score:     rating.Percentile(0.25),
+		P75Score:     rating.Percentile(0.75),
+		P90Score:     rating.Percentile(0.9),
+	}
+
+	for _, score := range rating.Scores() {
+		res.Histogram = append(res.Histogram, &pb.ScoreCount{
+			Score: score,
+			Count: rating.Histogram[score],
+		})
+	}
+
+	return res, nil
+}
+
+// TopRatedLaptops service sends the best rated laptops matching an optional filter, best first
+func (server *LaptopServer) TopRatedLaptops(req *pb.TopRatedLaptopsRequest, stream pb.LaptopService_TopRatedLaptopsServer) error {
+	filter := req.GetFilter()
+	limit := int(req.GetLimit())
+	if limit == 0 {
+		limit = defaultTopRatedLimit
+	}
+
+	log.Printf("recieved a top rated laptops request with limit %v and filter: %v", limit, filter)
+
+	// the laptops are found once the rating store is released, so the filter may skip any number of them
+	var rankedRatings []*RankedRating
+	err := server.RatingStore.TopRated(func(ranked *RankedRating) bool {
+		rankedRatings = append(rankedRatings, ranked)
+		return filter != nil || len(rankedRatings) < limit
+	})
+	if err != nil {
+		return status.Errorf(codes.Internal, "cannot rank laptops: %v", err)
+	}
+
+	rank := 0
+	for _, ranked := range rankedRatings {
+		laptop, found := server.LaptopStore.Find(ranked.LaptopID)
+		if !found || (filter != nil && !isQualified(filter, laptop)) {
+			continue
+		}
+
+		rank++
+		err := stream.Send(&pb.TopRatedLaptopsResponse{
+			Laptop:        laptop,
+			Rank:          uint32(rank),
+			RatedCount:    ranked.Rating.Count,
+			AverageScore:  ranked.Rating.Mean(),
+			WeightedScore: ranked.WeightedScore,
+		})
+		if err != nil {
+			return status.Errorf(codes.Unknown, "cannot send laptop: %v", err)
+		}
+
+		if rank == limit {
+			break
+		}
+	}
+
+	return nil
+}
+
+// Default number of reviews ListReviews returns
+const defaultReviewPageSize = 20
+
+// ListReviews service returns a page of the reviews of a laptop, newest first
+func (server *LaptopServer) ListReviews(ctx context.Context, req *pb.ListReviewsRequest) (*pb.ListReviewsResponse, error) {
+	laptopID := req.GetLaptopId()
+
+	log.Printf("recieved a list reviews request with laptop ID %v", laptopID)
+
+	_, found := server.LaptopStore.Find(laptopID)
+	if !found {
+		return nil, status.Errorf(codes.NotFound, "laptop with id, %v, not found", laptopID)
+	}
+
+	return listReviewPage(req.GetPageSize(), req.GetPageToken(), func(offset int, limit int) ([]*Review, error) {
+		return server.ReviewStore.List(laptopID, offset, limit)
+	})
+}
+
+// ListPendingReviews service returns a page of the reviews pending moderation, oldest first
+func (server *LaptopServer) ListPendingReviews(ctx context.Context, req *pb.ListPendingReviewsRequest) (*pb.ListReviewsResponse, error) {
+	log.Print("recieved a list pending reviews request")
+
+	return listReviewPage(req.GetPageSize(), req.GetPageToken(), server.ReviewStore.ListPending)
+}
+
+// listReviewPage returns the page of reviews listed by list with the page size and token of a request
+func listReviewPage(pageSize uint32, pageToken string, list func(offset int, limit int) ([]*Review, error)) (*pb.ListReviewsResponse, error) {
+	limit := int(pageSize)
+	if limit == 0 {
+		limit = defaultReviewPageSize
+	}
+
+	// the page token is the number of reviews already listed
+	offset := 0
+	if len(pageToken) > 0 {
+		var err error
+		offset, err = strconv.Atoi(pageToken)
+		if err != nil || offset < 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "page token %q is invalid", pageToken)
+		}
+	}
+
+	// one more review tells whether there's a next page
+	reviews, err := list(offset, limit+1)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot list reviews: %v", err)
+	}
+
+	res := &pb.ListReviewsResponse{}
+	if len(reviews) > limit {
+		reviews = reviews[:limit]
+		res.NextPageToken = strconv.Itoa(offset + limit)
+	}
+
+	for _, review := range reviews {
+		res.Reviews = append(res.Reviews, newReviewMessage(review))
+	}
+
+	return res, nil
+}
+
+// ModerateReview service approves or rejects a pending review. The score of an approved review is added to the laptop rating,
+// unless the user rated the laptop since writing the review, while a rejected review leaves the rating as it is.
+func (server *LaptopServer) ModerateReview(ctx context.Context, req *pb.ModerateReviewRequest) (*pb.ModerateReviewResponse, error) {
+	reviewID := req.GetReviewId()
+
+	log.Printf("recieved a moderate review request with review ID %v, approve: %v", reviewID, req.GetApprove())
+
+	review, err := server.ReviewStore.Moderate(reviewID, req.GetApprove(), req.GetReason())
+	if errors.Is(err, ErrNotFound) {
+		return nil, status.Errorf(codes.NotFound, "review with id, %v, not found", reviewID)
+	} else if errors.Is(err, ErrReviewNotPending) {
+		return nil, status.Errorf(codes.FailedPrecondition, "review with id, %v, is already moderated", reviewID)
+	} else if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot moderate review: %v", err)
+	}
+
+	if review.Status == ReviewApproved {
+		err = server.addReviewRating(review)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "unable to add rating to rating store: %v", err)
+		}
+	}
+
+	return &pb.ModerateReviewResponse{Review: newReviewMessage(review)}, nil
+}
+
+// ListMyReviews service returns the reviews of the user, including the ones pending moderation
+func (server *LaptopServer) ListMyReviews(ctx context.Context, req *pb.ListMyReviewsRequest) (*pb.ListMyReviewsResponse, error) {
+	claims, ok := ClaimsFromContext(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "listing your reviews requires an authenticated user")
+	}
+
+	log.Printf("recieved a list my reviews request from user %v", claims.Username)
+
+	reviews, err := server.ReviewStore.ListByUser(claims.Username)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot list reviews: %v", err)
+	}
+
+	res := &pb.ListMyReviewsResponse{}
+	for _, review := range reviews {
+		res.Reviews = append(res.Reviews, newReviewMessage(review))
+	}
+
+	return res, nil
+}
+
+// DeleteReview service removes a review, and the score of an approved review from the laptop rating.
+// Users can only remove their own reviews unless they're admins.
+func (server *LaptopServer) DeleteReview(ctx context.Context, req *pb.DeleteReviewRequest) (*pb.DeleteReviewResponse, error) {
+	reviewID := req.GetReviewId()
+
+	log.Printf("recieved a delete review request with review ID %v", reviewID)
+
+	claims, ok := ClaimsFromContext(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "deleting a review requires an authenticated user")
+	}
+
+	review, err := server.ReviewStore.Find(reviewID)
+	if errors.Is(err, ErrNotFound) {
+		return nil, status.Errorf(codes.NotFound, "review with id, %v, not found", reviewID)
+	} else if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot find review: %v", err)
+	}
+
+	if review.Username != claims.Username && claims.Role != "admin" {
+		return nil, status.Errorf(codes.PermissionDenied, "only the author or an admin can delete a review")
+	}
+
+	err = server.ReviewStore.Delete(reviewID)
+	if errors.Is(err, ErrNotFound) {
+		return nil, status.Errorf(codes.NotFound, "review with id, %v, not found", reviewID)
+	} else if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot delete review: %v", err)
+	}
+
+	if review.Status == ReviewApproved {
+		err = server.removeReviewRating(review)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "unable to remove rating from rating store: %v", err)
+		}
+	}
+
+	log.Printf("deleted review with ID: %v", reviewID)
+
+	return &pb.DeleteReviewResponse{}, nil
+}
+
+func newReviewMessage(review *Review) *pb.Review {
+	return &pb.Review{
+		ReviewId:  review.ID,
+		LaptopId:  review.LaptopID,
+		Username:  review.Username,
+		Score:     review.Score,
+		Title:     review.Title,
+		Body:      review.Body,
+		CreatedAt: timestamppb.New(review.CreatedAt),
+		UpdatedAt: timestamppb.New(review.UpdatedAt),
+
+		Status:           reviewStatuses[review.Status],
+		ModerationReason: review.ModerationReason,
+	}
+}
+
+var reviewStatuses = map[ReviewStatus]pb.Review_Status{
+	ReviewPending:  pb.Review_PENDING,
+	ReviewApproved: pb.Review_APPROVED,
+	ReviewRejected: pb.Review_REJECTED,
+}
+
+// WatchRatings service sends the rating of each watched laptop, then its new rating every time it changes
+func (server *LaptopServer) WatchRatings(req *pb.WatchRatingsRequest, stream pb.LaptopService_WatchRatingsServer) error {
+	laptopIDs := req.GetLaptopIds()
+
+	log.Printf("recieved a watch ratings request with laptop IDs %v", laptopIDs)
+
+	if len(laptopIDs) == 0 {
+		return status.Errorf(codes.InvalidArgument, "no laptop to watch")
+	}
+
+	for _, laptopID := range laptopIDs {
+		_, found := server.LaptopStore.Find(laptopID)
+		if !found {
+			return status.Errorf(codes.NotFound, "laptop with id, %v, not found", laptopID)
+		}
+	}
+
+	// subscribe before sending the current ratings, so no change is missed in between
+	sub := server.RatingHub.Subscribe(laptopIDs...)
+	defer server.RatingHub.Unsubscribe(sub)
+
+	for _, laptopID := range laptopIDs {
+		rating, err := server.RatingStore.Find(laptopID)
+		if errors.Is(err, ErrNotFound) {
+			// the laptop isn't rated yet
+			rating = &Rating{}
+		} else if err != nil {
+			return status.Errorf(codes.Internal, "cannot find rating: %v", err)
+		}
+
+		err = sendRatingUpdate(stream, &RatingUpdate{LaptopID: laptopID, Rating: rating})
+		if err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return contextError(stream.Context())
+		case <-sub.Ready():
+		}
+
+		for _, update := range sub.Updates() {
+			err := sendRatingUpdate(stream, update)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func sendRatingUpdate(stream pb.LaptopService_WatchRatingsServer, update *RatingUpdate) error {
+	err := stream.Send(newRateLaptopResponse(update.LaptopID, update.Rating))
+	if err != nil {
+		return status.Errorf(codes.Unknown, "cannot send rating: %v", err)
+	}
+
+	return nil
+}
+
+// ListRatingAudit service returns the ratings rejected by the rating limits, newest first
+func (server *LaptopServer) ListRatingAudit(ctx context.Context, req *pb.ListRatingAuditRequest) (*pb.ListRatingAuditResponse, error) {
+	log.Printf("recieved a list rating audit request for user %q", req.GetUsername())
+
+	limit := int(req.GetLimit())
+	if limit == 0 {
+		limit = defaultRatingAuditLimit
+	}
+
+	entries, err := server.RatingAuditStore.List(req.GetUsername(), limit)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot list rating audit: %v", err)
+	}
+
+	res := &pb.ListRatingAuditResponse{}
+	for _, entry := range entries {
+		res.Entries = append(res.Entries, &pb.RatingAuditEntry{
+			Username:   entry.Username,
+			LaptopId:   entry.LaptopID,
+			Reason:     entry.Reason,
+			RecordedAt: timestamppb.New(entry.RecordedAt),
+		})
+	}
+
+	return res, nil
+}
+
+// GetRatingConfig service returns the scale of the scores laptops can be rated with
+func (server *LaptopServer) GetRatingConfig(ctx context.Context, req *pb.GetRatingConfigRequest) (*pb.GetRatingConfigResponse, error) {
+	res := &pb.GetRatingConfigResponse{
+		MinScore:  server.RatingScale.Min,
+		MaxScore:  server.RatingScale.Max,
+		ScoreStep: server.RatingScale.Step,
+	}
+
+	return res, nil
+}
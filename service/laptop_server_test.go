@@ -6,6 +6,7 @@ import (
 	"grpc_youtube_tutorial/sample"
 	"grpc_youtube_tutorial/service"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc/codes"
@@ -22,6 +23,9 @@ func TestServerCreateLaptop(t *testing.T) {
 	laptopInvalidID := sample.NewLaptop()
 	laptopInvalidID.Id = "Jibberish"
 
+	laptopInvalid := sample.NewLaptop()
+	laptopInvalid.PriceUsd = -1
+
 	laptopDuplicate := sample.NewLaptop()
 	storeDuplicate := service.NewInMemoryLaptopStore()
 	storeDuplicate.Save(laptopDuplicate)
@@ -48,6 +52,11 @@ func TestServerCreateLaptop(t *testing.T) {
 			laptop: laptopInvalidID,
 			store:  service.NewInMemoryLaptopStore(),
 			code:   codes.InvalidArgument,
+		}, {
+			name:   "failure_invalid_laptop",
+			laptop: laptopInvalid,
+			store:  service.NewInMemoryLaptopStore(),
+			code:   codes.InvalidArgument,
 		}, {
 			name:   "failure_duplicate_id",
 			laptop: laptopDuplicate,
@@ -85,3 +94,49 @@ func TestServerCreateLaptop(t *testing.T) {
 		})
 	}
 }
+
+// stalledSearchStream is a search stream whose Send blocks until release is closed,
+// like a client that stopped reading
+type stalledSearchStream struct {
+	pb.LaptopService_SearchLaptopServer
+	sending chan struct{}
+	release chan struct{}
+}
+
+func (stream *stalledSearchStream) Send(res *pb.SearchLaptopResponse) error {
+	stream.sending <- struct{}{}
+	<-stream.release
+	return nil
+}
+
+func TestServerSearchLaptopDoesNotBlockCreateLaptop(t *testing.T) {
+	t.Parallel()
+
+	store := service.NewInMemoryLaptopStore()
+	err := store.Save(sample.NewLaptop())
+	require.NoError(t, err)
+
+	server := service.NewLaptopServer(store, nil, nil)
+
+	stream := &stalledSearchStream{
+		sending: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	defer close(stream.release)
+
+	go server.SearchLaptop(&pb.SearchLaptopRequest{Filter: &pb.Filter{MaxPriceUsd: 5000}}, stream)
+	<-stream.sending
+
+	created := make(chan error)
+	go func() {
+		_, err := server.CreateLaptop(context.Background(), &pb.CreateLaptopRequest{Laptop: sample.NewLaptop()})
+		created <- err
+	}()
+
+	select {
+	case err := <-created:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("create laptop blocked by a stalled search stream")
+	}
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+// RatingAuditStore is an interface to store the ratings rejected by the rating limits
+type RatingAuditStore interface {
+	// Record saves an audit entry
+	Record(entry *RatingAuditEntry) error
+	// List returns at most limit entries of the user, or of every user if username is empty, newest first
+	List(username string, limit int) ([]*RatingAuditEntry, error)
+}
+
+// RatingAuditEntry is a rating that was rejected, and why
+type RatingAuditEntry struct {
+	Username   string
+	LaptopID   string
+	Reason     string
+	RecordedAt time.Time
+}
+
+// InMemoryRatingAuditStore stores the latest audit entries in memory
+type InMemoryRatingAuditStore struct {
+	mutex   sync.RWMutex
+	entries []*RatingAuditEntry
+
+	// MaxEntries is the number of entries kept, the oldest are dropped first
+	MaxEntries int
+}
+
+// Default number of audit entries kept in memory
+const defaultMaxAuditEntries = 10000
+
+// NewInMemoryRatingAuditStore returns a new InMemoryRatingAuditStore
+func NewInMemoryRatingAuditStore() *InMemoryRatingAuditStore {
+	return &InMemoryRatingAuditStore{
+		MaxEntries: defaultMaxAuditEntries,
+	}
+}
+
+// Record saves an audit entry
+func (store *InMemoryRatingAuditStore) Record(entry *RatingAuditEntry) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	other := *entry
+	store.entries = append(store.entries, &other)
+
+	if len(store.entries) > store.MaxEntries {
+		store.entries = store.entries[len(store.entries)-store.MaxEntries:]
+	}
+
+	return nil
+}
+
+// List returns at most limit entries of the user, or of every user if username is empty, newest first
+func (store *InMemoryRatingAuditStore) List(username string, limit int) ([]*RatingAuditEntry, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	var entries []*RatingAuditEntry
+	for i := len(store.entries) - 1; i >= 0 && len(entries) < limit; i-- {
+		entry := store.entries[i]
+		if len(username) > 0 && entry.Username != username {
+			continue
+		}
+
+		other := *entry
+		entries = append(entries, &other)
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,40 @@
+package service_test
+
+import (
+	"grpc_youtube_tutorial/service"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInMemoryRatingAuditStore(t *testing.T) {
+	t.Parallel()
+
+	store := service.NewInMemoryRatingAuditStore()
+	store.MaxEntries = 3
+
+	for _, entry := range []*service.RatingAuditEntry{
+		{Username: "user1", LaptopID: "laptop1"},
+		{Username: "user2", LaptopID: "laptop1"},
+		{Username: "user1", LaptopID: "laptop2"},
+		{Username: "user1", LaptopID: "laptop3"},
+	} {
+		require.NoError(t, store.Record(entry))
+	}
+
+	// the oldest entry is dropped
+	entries, err := store.List("", 10)
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	require.Equal(t, "laptop3", entries[0].LaptopID)
+	require.Equal(t, "laptop1", entries[2].LaptopID)
+
+	entries, err = store.List("user1", 1)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "laptop3", entries[0].LaptopID)
+
+	entries, err = store.List("user3", 10)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
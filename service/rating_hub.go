@@ -0,0 +1,116 @@
+package service
+
+import "sync"
+
+// RatingHub publishes the ratings of laptops to the subscriptions watching them
+type RatingHub struct {
+	mutex sync.Mutex
+	// subscriptions of each laptop ID
+	subscriptions map[string]map[*RatingSubscription]bool
+}
+
+// NewRatingHub returns a new RatingHub
+func NewRatingHub() *RatingHub {
+	return &RatingHub{
+		subscriptions: make(map[string]map[*RatingSubscription]bool),
+	}
+}
+
+// RatingUpdate is the rating of a laptop after it changed
+type RatingUpdate struct {
+	LaptopID string
+	Rating   *Rating
+}
+
+// RatingSubscription receives the rating updates of the laptops it watches.
+// Updates a subscriber hasn't taken yet are replaced by newer updates of the same laptop,
+// so a slow subscriber never blocks publishers and always gets the latest ratings.
+type RatingSubscription struct {
+	laptopIDs []string
+
+	mutex   sync.Mutex
+	pending map[string]*Rating
+	order   []string
+	ready   chan struct{}
+}
+
+// Subscribe returns a subscription to the ratings of the laptops with the Ids of laptopIDs
+func (hub *RatingHub) Subscribe(laptopIDs ...string) *RatingSubscription {
+	sub := &RatingSubscription{
+		laptopIDs: laptopIDs,
+		pending:   make(map[string]*Rating),
+		ready:     make(chan struct{}, 1),
+	}
+
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	for _, laptopID := range laptopIDs {
+		if hub.subscriptions[laptopID] == nil {
+			hub.subscriptions[laptopID] = make(map[*RatingSubscription]bool)
+		}
+		hub.subscriptions[laptopID][sub] = true
+	}
+
+	return sub
+}
+
+// Unsubscribe stops publishing to the subscription
+func (hub *RatingHub) Unsubscribe(sub *RatingSubscription) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	for _, laptopID := range sub.laptopIDs {
+		delete(hub.subscriptions[laptopID], sub)
+		if len(hub.subscriptions[laptopID]) == 0 {
+			delete(hub.subscriptions, laptopID)
+		}
+	}
+}
+
+// Publish sends the new rating of the laptop with the Id of laptopID to the subscriptions watching it
+func (hub *RatingHub) Publish(laptopID string, rating *Rating) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	for sub := range hub.subscriptions[laptopID] {
+		sub.push(laptopID, rating.clone())
+	}
+}
+
+// push queues the rating of a laptop, replacing the one not taken yet
+func (sub *RatingSubscription) push(laptopID string, rating *Rating) {
+	sub.mutex.Lock()
+	defer sub.mutex.Unlock()
+
+	if _, ok := sub.pending[laptopID]; !ok {
+		sub.order = append(sub.order, laptopID)
+	}
+	sub.pending[laptopID] = rating
+
+	select {
+	case sub.ready <- struct{}{}:
+	default:
+	}
+}
+
+// Ready returns a channel that receives a value when updates are waiting to be taken
+func (sub *RatingSubscription) Ready() <-chan struct{} {
+	return sub.ready
+}
+
+// Updates takes the waiting updates, in the order their laptops were first updated
+func (sub *RatingSubscription) Updates() []*RatingUpdate {
+	sub.mutex.Lock()
+	defer sub.mutex.Unlock()
+
+	updates := make([]*RatingUpdate, 0, len(sub.order))
+	for _, laptopID := range sub.order {
+		updates = append(updates, &RatingUpdate{LaptopID: laptopID, Rating: sub.pending[laptopID]})
+	}
+
+	sub.pending = make(map[string]*Rating)
+	sub.order = nil
+
+	return updates
+}
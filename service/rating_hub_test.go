@@ -0,0 +1,42 @@
+package service_test
+
+import (
+	"grpc_youtube_tutorial/service"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRatingHub(t *testing.T) {
+	t.Parallel()
+
+	hub := service.NewRatingHub()
+
+	sub := hub.Subscribe("laptop1", "laptop2")
+	other := hub.Subscribe("laptop2")
+
+	hub.Publish("laptop2", &service.Rating{Count: 1, Sum: 5})
+	hub.Publish("laptop1", &service.Rating{Count: 1, Sum: 8})
+	hub.Publish("laptop3", &service.Rating{Count: 1, Sum: 1})
+	// updates not taken yet are replaced by newer ones
+	hub.Publish("laptop2", &service.Rating{Count: 2, Sum: 12})
+
+	<-sub.Ready()
+	updates := sub.Updates()
+	require.Len(t, updates, 2)
+	require.Equal(t, "laptop2", updates[0].LaptopID)
+	require.Equal(t, 6.0, updates[0].Rating.Mean())
+	require.Equal(t, "laptop1", updates[1].LaptopID)
+	require.Equal(t, 8.0, updates[1].Rating.Mean())
+	require.Empty(t, sub.Updates())
+
+	<-other.Ready()
+	updates = other.Updates()
+	require.Len(t, updates, 1)
+	require.Equal(t, uint32(2), updates[0].Rating.Count)
+
+	hub.Unsubscribe(sub)
+	hub.Publish("laptop1", &service.Rating{Count: 2, Sum: 16})
+	require.Empty(t, sub.Updates())
+	require.Empty(t, other.Updates())
+}
@@ -0,0 +1,122 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrRatingLimited for ratings over the limits of a RatingLimiter
+var ErrRatingLimited = errors.New("rating limit exceeded")
+
+// RatingLimiter limits how often users rate laptops, and how many new accounts rate a laptop at once
+type RatingLimiter struct {
+	mutex sync.Mutex
+	// userRatings holds the times of the recent ratings of every user
+	userRatings map[string][]time.Time
+	// newAccountRatings holds the time every new account last rated a laptop, by laptop ID, then username
+	newAccountRatings map[string]map[string]time.Time
+
+	// UserLimit is the number of ratings a user can give in UserWindow, 0 for no limit
+	UserLimit  int
+	UserWindow time.Duration
+	// NewAccountAge is the age under which accounts are new
+	NewAccountAge time.Duration
+	// BurstLimit is the number of new accounts that can rate a laptop in BurstWindow, 0 for no limit
+	BurstLimit  int
+	BurstWindow time.Duration
+	// Now returns the current time
+	Now func() time.Time
+}
+
+// Default limits are 30 ratings a minute per user, and 10 accounts of less than a day rating a laptop in 10 minutes
+const (
+	defaultUserRatingLimit  = 30
+	defaultUserRatingWindow = time.Minute
+	defaultNewAccountAge    = 24 * time.Hour
+	defaultBurstLimit       = 10
+	defaultBurstWindow      = 10 * time.Minute
+)
+
+// NewRatingLimiter returns a RatingLimiter with the default limits
+func NewRatingLimiter() *RatingLimiter {
+	return &RatingLimiter{
+		userRatings:       make(map[string][]time.Time),
+		newAccountRatings: make(map[string]map[string]time.Time),
+		UserLimit:         defaultUserRatingLimit,
+		UserWindow:        defaultUserRatingWindow,
+		NewAccountAge:     defaultNewAccountAge,
+		BurstLimit:        defaultBurstLimit,
+		BurstWindow:       defaultBurstWindow,
+		Now:               time.Now,
+	}
+}
+
+// Allow records a rating of the laptop with the Id of laptopID by a user whose account was created at accountCreatedAt,
+// or returns an error wrapping ErrRatingLimited if the rating is over the limits.
+// A zero accountCreatedAt is an account of unknown age, which isn't new.
+func (limiter *RatingLimiter) Allow(username string, accountCreatedAt time.Time, laptopID string) error {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	now := limiter.Now()
+
+	ratedAt := limiter.recentUserRatings(username, now)
+	if limiter.UserLimit > 0 && len(ratedAt) >= limiter.UserLimit {
+		return fmt.Errorf("%w: user %v rated %d times in the last %v", ErrRatingLimited, username, len(ratedAt), limiter.UserWindow)
+	}
+
+	newAccount := !accountCreatedAt.IsZero() && now.Sub(accountCreatedAt) < limiter.NewAccountAge
+	if newAccount {
+		newAccounts := limiter.recentNewAccounts(laptopID, now)
+
+		_, rated := newAccounts[username]
+		if limiter.BurstLimit > 0 && !rated && len(newAccounts) >= limiter.BurstLimit {
+			return fmt.Errorf("%w: laptop %v was rated by %d new accounts in the last %v",
+				ErrRatingLimited, laptopID, len(newAccounts), limiter.BurstWindow)
+		}
+
+		newAccounts[username] = now
+	}
+
+	limiter.userRatings[username] = append(ratedAt, now)
+
+	return nil
+}
+
+// recentUserRatings drops the ratings of the user older than UserWindow and returns the others,
+// it must be called with the lock held
+func (limiter *RatingLimiter) recentUserRatings(username string, now time.Time) []time.Time {
+	ratedAt := limiter.userRatings[username]
+
+	i := 0
+	for i < len(ratedAt) && now.Sub(ratedAt[i]) >= limiter.UserWindow {
+		i++
+	}
+	ratedAt = ratedAt[i:]
+
+	if len(ratedAt) == 0 {
+		delete(limiter.userRatings, username)
+	}
+
+	return ratedAt
+}
+
+// recentNewAccounts drops the new accounts that rated the laptop before BurstWindow and returns the others,
+// it must be called with the lock held
+func (limiter *RatingLimiter) recentNewAccounts(laptopID string, now time.Time) map[string]time.Time {
+	newAccounts := limiter.newAccountRatings[laptopID]
+	if newAccounts == nil {
+		newAccounts = make(map[string]time.Time)
+		limiter.newAccountRatings[laptopID] = newAccounts
+	}
+
+	for username, ratedAt := range newAccounts {
+		if now.Sub(ratedAt) >= limiter.BurstWindow {
+			delete(newAccounts, username)
+		}
+	}
+
+	return newAccounts
+}
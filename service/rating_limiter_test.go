@@ -0,0 +1,66 @@
+package service_test
+
+import (
+	"errors"
+	"grpc_youtube_tutorial/service"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRatingLimiterUserLimit(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2020, time.June, 1, 0, 0, 0, 0, time.UTC)
+
+	limiter := service.NewRatingLimiter()
+	limiter.UserLimit = 3
+	limiter.Now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, limiter.Allow("user1", time.Time{}, "laptop1"))
+		now = now.Add(10 * time.Second)
+	}
+
+	err := limiter.Allow("user1", time.Time{}, "laptop2")
+	require.True(t, errors.Is(err, service.ErrRatingLimited))
+
+	// other users have their own limit
+	require.NoError(t, limiter.Allow("user2", time.Time{}, "laptop1"))
+
+	// the first rating is a minute old
+	now = now.Add(30 * time.Second)
+	require.NoError(t, limiter.Allow("user1", time.Time{}, "laptop2"))
+
+	err = limiter.Allow("user1", time.Time{}, "laptop2")
+	require.True(t, errors.Is(err, service.ErrRatingLimited))
+}
+
+func TestRatingLimiterBurstLimit(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2020, time.June, 1, 0, 0, 0, 0, time.UTC)
+	newAccount := now.Add(-time.Hour)
+	oldAccount := now.Add(-30 * 24 * time.Hour)
+
+	limiter := service.NewRatingLimiter()
+	limiter.BurstLimit = 2
+	limiter.Now = func() time.Time { return now }
+
+	require.NoError(t, limiter.Allow("new1", newAccount, "laptop1"))
+	require.NoError(t, limiter.Allow("new2", newAccount, "laptop1"))
+	// the accounts that already rated the laptop can rate it again
+	require.NoError(t, limiter.Allow("new1", newAccount, "laptop1"))
+
+	err := limiter.Allow("new3", newAccount, "laptop1")
+	require.True(t, errors.Is(err, service.ErrRatingLimited))
+
+	// old accounts, and accounts of unknown age, aren't limited
+	require.NoError(t, limiter.Allow("old", oldAccount, "laptop1"))
+	require.NoError(t, limiter.Allow("unknown", time.Time{}, "laptop1"))
+	require.NoError(t, limiter.Allow("new3", newAccount, "laptop2"))
+
+	now = now.Add(10 * time.Minute)
+	require.NoError(t, limiter.Allow("new3", newAccount, "laptop1"))
+}
@@ -0,0 +1,44 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ErrInvalidScore for scores that are not on the rating scale
+var ErrInvalidScore = errors.New("invalid score")
+
+// RatingScale is the range of scores laptops can be rated with
+type RatingScale struct {
+	Min float64
+	Max float64
+	// Step is the granularity of scores from Min, like 0.5 for half steps. 0 allows any score in the range.
+	Step float64
+}
+
+// DefaultRatingScale rates laptops from 1 to 10
+var DefaultRatingScale = RatingScale{Min: 1, Max: 10}
+
+// scoreStepTolerance absorbs the rounding errors of scores sent as doubles
+const scoreStepTolerance = 1e-9
+
+// Validate checks that score is on the scale
+func (scale RatingScale) Validate(score float64) error {
+	if math.IsNaN(score) || math.IsInf(score, 0) {
+		return fmt.Errorf("%w: score must be a number", ErrInvalidScore)
+	}
+
+	if score < scale.Min || score > scale.Max {
+		return fmt.Errorf("%w: score %v is not between %v and %v", ErrInvalidScore, score, scale.Min, scale.Max)
+	}
+
+	if scale.Step > 0 {
+		steps := (score - scale.Min) / scale.Step
+		if math.Abs(steps-math.Round(steps)) > scoreStepTolerance {
+			return fmt.Errorf("%w: score %v is not a multiple of %v from %v", ErrInvalidScore, score, scale.Step, scale.Min)
+		}
+	}
+
+	return nil
+}
@@ -1,47 +1,497 @@
 package service
 
-import "sync"
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
 
 // RatingStore is an interface to store laptop ratings
 type RatingStore interface {
-	Add(laptopID string, score float64) (*Rating, error)
+	// Add sets the score given by a user to a laptop, replacing the user's previous score.
+	// It returns the laptop rating, and whether the user had already rated the laptop.
+	Add(laptopID string, username string, score float64) (*Rating, bool, error)
+	// AddAt is Add for a score given at ratedAt rather than now
+	AddAt(laptopID string, username string, score float64, ratedAt time.Time) (*Rating, bool, error)
+	// Remove removes the score given by a user to a laptop and returns the laptop rating,
+	// or ErrNotFound if the user hasn't rated the laptop
+	Remove(laptopID string, username string) (*Rating, error)
+	// RatedAt returns when a user last rated a laptop, or ErrNotFound if the user hasn't rated the laptop
+	RatedAt(laptopID string, username string) (time.Time, error)
+	// Find returns the rating of the laptop with the Id of laptopID
+	Find(laptopID string) (*Rating, error)
+	// TopRated calls found with the rated laptops, highest weighted score first, until found returns false
+	TopRated(found func(ranked *RankedRating) bool) error
+}
+
+// RankedRating is the rating of a laptop in the top rated laptops
+type RankedRating struct {
+	LaptopID string
+	Rating   *Rating
+	// WeightedScore is the average score pulled toward the prior score, the fewer ratings the more
+	WeightedScore float64
 }
 
 // Rating contains the rating information for given laptop
 type Rating struct {
 	Count uint32
 	Sum   float64
+	// SumOfSquares is the sum of the squared scores, for the standard deviation
+	SumOfSquares float64
+	// Histogram counts the ratings by score
+	Histogram map[float64]uint32
+
+	// RecentCount and RecentSum only count the scores given in the RecentWindow of the store,
+	// when the rating was returned
+	RecentCount uint32
+	RecentSum   float64
+	// DecayedScore is the average score weighted by age when the rating was returned,
+	// a score loses half its weight every HalfLife of the store
+	DecayedScore float64
+}
+
+// Mean returns the average score
+func (rating *Rating) Mean() float64 {
+	if rating.Count == 0 {
+		return 0
+	}
+	return rating.Sum / float64(rating.Count)
+}
+
+// RecentMean returns the average score of the recent ratings
+func (rating *Rating) RecentMean() float64 {
+	if rating.RecentCount == 0 {
+		return 0
+	}
+	return rating.RecentSum / float64(rating.RecentCount)
+}
+
+// StdDev returns the standard deviation of the scores
+func (rating *Rating) StdDev() float64 {
+	if rating.Count == 0 {
+		return 0
+	}
+
+	mean := rating.Mean()
+	variance := rating.SumOfSquares/float64(rating.Count) - mean*mean
+
+	// rounding errors can make the variance of equal scores slightly negative
+	return math.Sqrt(math.Max(variance, 0))
+}
+
+// Scores returns the distinct scores of the histogram in ascending order
+func (rating *Rating) Scores() []float64 {
+	scores := make([]float64, 0, len(rating.Histogram))
+	for score := range rating.Histogram {
+		scores = append(scores, score)
+	}
+	sort.Float64s(scores)
+	return scores
+}
+
+// Percentile returns the score below which p (between 0 and 1) of the scores fall,
+// interpolating linearly between the closest ranks
+func (rating *Rating) Percentile(p float64) float64 {
+	if rating.Count == 0 {
+		return 0
+	}
+
+	rank := p * float64(rating.Count-1)
+	lower := rating.scoreAt(uint32(math.Floor(rank)))
+	upper := rating.scoreAt(uint32(math.Ceil(rank)))
+
+	return lower + (upper-lower)*(rank-math.Floor(rank))
+}
+
+// scoreAt returns the score at index i of the sorted scores
+func (rating *Rating) scoreAt(i uint32) float64 {
+	scores := rating.Scores()
+
+	var seen uint32
+	for _, score := range scores {
+		seen += rating.Histogram[score]
+		if i < seen {
+			return score
+		}
+	}
+
+	return scores[len(scores)-1]
+}
+
+// clone returns a deep copy of the rating
+func (rating *Rating) clone() *Rating {
+	other := *rating
+	other.Histogram = make(map[float64]uint32, len(rating.Histogram))
+	for score, count := range rating.Histogram {
+		other.Histogram[score] = count
+	}
+	return &other
+}
+
+// add adds a score to the rating
+func (rating *Rating) add(score float64) {
+	rating.Count++
+	rating.Sum += score
+	rating.SumOfSquares += score * score
+	rating.Histogram[score]++
+}
+
+// remove removes a score added to the rating
+func (rating *Rating) remove(score float64) {
+	rating.Count--
+	rating.Sum -= score
+	rating.SumOfSquares -= score * score
+
+	rating.Histogram[score]--
+	if rating.Histogram[score] == 0 {
+		delete(rating.Histogram, score)
+	}
 }
 
 // InMemoryRatingStore stores laptop ratings inMemory
 type InMemoryRatingStore struct {
 	mutex  sync.RWMutex
 	rating map[string]*Rating
+	// scores indexes the score given by every user by laptop ID, then username
+	scores map[string]map[string]ratedScore
+	// aging holds the recent and decayed aggregates of every laptop ID
+	aging map[string]*ratingAging
+	// ranking holds the rated laptop IDs, highest weighted score first
+	ranking []string
+
+	// PriorScore and PriorWeight rank laptops by a Bayesian average: the ratings of every laptop
+	// are counted with PriorWeight extra ratings of PriorScore, so a few high scores don't beat many.
+	// They must be set before adding ratings.
+	PriorScore  float64
+	PriorWeight float64
+	// MinVotes is the number of ratings a laptop needs to be top rated
+	MinVotes uint32
+	// RecentWindow is how long ratings count as recent, 0 for all of them.
+	// It must be set before adding ratings.
+	RecentWindow time.Duration
+	// HalfLife is the age at which a score weighs half as much in the decayed score, 0 for no decay.
+	// It must be set before adding ratings.
+	HalfLife time.Duration
+	// Now returns the current time, the time of new ratings
+	Now func() time.Time
+}
+
+// ratedScore is a score and the time it was given
+type ratedScore struct {
+	score   float64
+	ratedAt time.Time
+	// recent is whether the score is counted in the recent aggregates
+	recent bool
 }
 
+// ratingAging holds the aggregates of a laptop rating that depend on the age of the scores,
+// updated as scores are added and removed rather than computed from all the scores
+type ratingAging struct {
+	// recent holds the scores counted in the recent aggregates, oldest first, until they expire.
+	// Replaced scores stay in it, but are skipped when they expire.
+	recent      []queuedScore
+	recentCount uint32
+	recentSum   float64
+
+	// decayedSum and decayedWeight are the sums of the weighted scores and of their weights as of decayedAt,
+	// the time of the newest score
+	decayedSum    float64
+	decayedWeight float64
+	decayedAt     time.Time
+}
+
+// queuedScore is a score in the recent queue of a laptop
+type queuedScore struct {
+	username string
+	ratedAt  time.Time
+}
+
+// Default prior is 10 ratings in the middle of the default rating scale
+const (
+	defaultPriorScore  = 5.5
+	defaultPriorWeight = 10
+)
+
+// Default ratings are recent for 30 days, and lose half their weight in 90 days
+const (
+	defaultRecentWindow = 30 * 24 * time.Hour
+	defaultHalfLife     = 90 * 24 * time.Hour
+)
+
 // NewInMemoryRatingStore returns a InMemoryRatingStore
 func NewInMemoryRatingStore() *InMemoryRatingStore {
 	return &InMemoryRatingStore{
-		rating: make(map[string]*Rating),
+		rating:       make(map[string]*Rating),
+		scores:       make(map[string]map[string]ratedScore),
+		aging:        make(map[string]*ratingAging),
+		PriorScore:   defaultPriorScore,
+		PriorWeight:  defaultPriorWeight,
+		MinVotes:     1,
+		RecentWindow: defaultRecentWindow,
+		HalfLife:     defaultHalfLife,
+		Now:          time.Now,
 	}
 }
 
-// Add adds a rating to the Rating store
-func (store *InMemoryRatingStore) Add(laptopID string, score float64) (*Rating, error) {
+// Add sets the score given by a user to a laptop in the Rating store
+func (store *InMemoryRatingStore) Add(laptopID string, username string, score float64) (*Rating, bool, error) {
+	return store.AddAt(laptopID, username, score, store.Now())
+}
+
+// AddAt sets the score given by a user to a laptop at ratedAt in the Rating store
+func (store *InMemoryRatingStore) AddAt(laptopID string, username string, score float64, ratedAt time.Time) (*Rating, bool, error) {
 	store.mutex.Lock()
 	defer store.mutex.Unlock()
 
+	scores := store.scores[laptopID]
+	if scores == nil {
+		scores = make(map[string]ratedScore)
+		store.scores[laptopID] = scores
+	}
+
 	rating := store.rating[laptopID]
 	if rating == nil {
-		rating = &Rating{
-			Count: 1,
-			Sum:   score,
-		}
+		rating = &Rating{Histogram: make(map[float64]uint32)}
+		store.rating[laptopID] = rating
+		store.aging[laptopID] = &ratingAging{}
 	} else {
-		rating.Count++
-		rating.Sum += score
+		store.unrank(laptopID)
+	}
+
+	now := store.Now()
+
+	previous, updated := scores[username]
+	if updated {
+		rating.remove(previous.score)
+		store.untrack(laptopID, previous)
+	}
+	rating.add(score)
+	scores[username] = store.track(laptopID, username, ratedScore{score: score, ratedAt: ratedAt}, now)
+
+	store.rank(laptopID)
+
+	return store.snapshot(laptopID, now), updated, nil
+}
+
+// Remove removes the score given by a user to a laptop from the Rating store,
+// the laptop is no longer rated once its last score is removed
+func (store *InMemoryRatingStore) Remove(laptopID string, username string) (*Rating, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	previous, ok := store.scores[laptopID][username]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	store.unrank(laptopID)
+
+	rating := store.rating[laptopID]
+	rating.remove(previous.score)
+	store.untrack(laptopID, previous)
+	delete(store.scores[laptopID], username)
+
+	if rating.Count == 0 {
+		delete(store.rating, laptopID)
+		delete(store.scores, laptopID)
+		delete(store.aging, laptopID)
+		return &Rating{Histogram: make(map[float64]uint32)}, nil
+	}
+
+	store.rank(laptopID)
+
+	return store.snapshot(laptopID, store.Now()), nil
+}
+
+// RatedAt returns when a user last rated a laptop
+func (store *InMemoryRatingStore) RatedAt(laptopID string, username string) (time.Time, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	rated, ok := store.scores[laptopID][username]
+	if !ok {
+		return time.Time{}, ErrNotFound
+	}
+
+	return rated.ratedAt, nil
+}
+
+// Find returns the rating of the laptop with the Id of laptopID
+func (store *InMemoryRatingStore) Find(laptopID string) (*Rating, error) {
+	// expired scores are removed from the recent aggregates
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.rating[laptopID] == nil {
+		return nil, ErrNotFound
 	}
 
-	store.rating[laptopID] = rating
-	return store.rating[laptopID], nil
+	return store.snapshot(laptopID, store.Now()), nil
+}
+
+// TopRated calls found with the laptops rated at least MinVotes times, highest weighted score first,
+// until found returns false. found is called with the lock held, so it must not use the store.
+func (store *InMemoryRatingStore) TopRated(found func(ranked *RankedRating) bool) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	now := store.Now()
+
+	for _, laptopID := range store.ranking {
+		rating := store.rating[laptopID]
+		if rating.Count < store.MinVotes {
+			continue
+		}
+
+		ranked := &RankedRating{
+			LaptopID:      laptopID,
+			Rating:        store.snapshot(laptopID, now),
+			WeightedScore: store.weightedScore(rating),
+		}
+		if !found(ranked) {
+			break
+		}
+	}
+
+	return nil
+}
+
+// snapshot returns a copy of the rating of the laptop with its recent and decayed scores as of now,
+// it must be called with the write lock held
+func (store *InMemoryRatingStore) snapshot(laptopID string, now time.Time) *Rating {
+	store.expire(laptopID, now)
+
+	rating := store.rating[laptopID].clone()
+	aging := store.aging[laptopID]
+
+	rating.RecentCount = aging.recentCount
+	rating.RecentSum = aging.recentSum
+
+	// all the weights decay at the same rate as time goes by, rescaling the sums to now doesn't change their ratio
+	if aging.decayedWeight > 0 {
+		rating.DecayedScore = aging.decayedSum / aging.decayedWeight
+	}
+
+	return rating
+}
+
+// track adds a score given by the user to the aging aggregates of the laptop and returns it,
+// it must be called with the write lock held
+func (store *InMemoryRatingStore) track(laptopID string, username string, rated ratedScore, now time.Time) ratedScore {
+	aging := store.aging[laptopID]
+
+	if store.RecentWindow == 0 || now.Sub(rated.ratedAt) <= store.RecentWindow {
+		rated.recent = true
+		aging.recentCount++
+		aging.recentSum += rated.score
+
+		if store.RecentWindow > 0 {
+			// scores given in the past are queued by the time they were given
+			i := sort.Search(len(aging.recent), func(i int) bool {
+				return aging.recent[i].ratedAt.After(rated.ratedAt)
+			})
+			aging.recent = append(aging.recent, queuedScore{})
+			copy(aging.recent[i+1:], aging.recent[i:])
+			aging.recent[i] = queuedScore{username: username, ratedAt: rated.ratedAt}
+		}
+	}
+
+	// the sums are rescaled to the newest score, so that no weight is over 1
+	if rated.ratedAt.After(aging.decayedAt) {
+		decay := store.decay(rated.ratedAt.Sub(aging.decayedAt))
+		aging.decayedSum *= decay
+		aging.decayedWeight *= decay
+		aging.decayedAt = rated.ratedAt
+	}
+
+	weight := store.decay(aging.decayedAt.Sub(rated.ratedAt))
+	aging.decayedSum += weight * rated.score
+	aging.decayedWeight += weight
+
+	return rated
+}
+
+// untrack removes a score from the aging aggregates of the laptop, it must be called with the write lock held
+func (store *InMemoryRatingStore) untrack(laptopID string, rated ratedScore) {
+	aging := store.aging[laptopID]
+
+	if rated.recent {
+		aging.removeRecent(rated.score)
+	}
+
+	weight := store.decay(aging.decayedAt.Sub(rated.ratedAt))
+	aging.decayedSum -= weight * rated.score
+	aging.decayedWeight -= weight
+}
+
+// expire removes the scores older than RecentWindow from the recent aggregates of the laptop,
+// it must be called with the write lock held
+func (store *InMemoryRatingStore) expire(laptopID string, now time.Time) {
+	aging := store.aging[laptopID]
+	scores := store.scores[laptopID]
+
+	i := 0
+	for ; i < len(aging.recent) && now.Sub(aging.recent[i].ratedAt) > store.RecentWindow; i++ {
+		queued := aging.recent[i]
+
+		// the queued score may have been replaced since
+		rated, ok := scores[queued.username]
+		if ok && rated.recent && rated.ratedAt.Equal(queued.ratedAt) {
+			aging.removeRecent(rated.score)
+			rated.recent = false
+			scores[queued.username] = rated
+		}
+	}
+	aging.recent = aging.recent[i:]
+}
+
+// removeRecent removes a score from the recent aggregates
+func (aging *ratingAging) removeRecent(score float64) {
+	aging.recentCount--
+	aging.recentSum -= score
+
+	if aging.recentCount == 0 {
+		// don't leave rounding errors behind
+		aging.recentSum = 0
+	}
+}
+
+// decay returns the weight of a score of the given age, relative to a new score
+func (store *InMemoryRatingStore) decay(age time.Duration) float64 {
+	if store.HalfLife == 0 {
+		return 1
+	}
+	return math.Exp2(-age.Seconds() / store.HalfLife.Seconds())
+}
+
+// weightedScore returns the Bayesian average of the rating
+func (store *InMemoryRatingStore) weightedScore(rating *Rating) float64 {
+	return (store.PriorScore*store.PriorWeight + rating.Sum) / (store.PriorWeight + float64(rating.Count))
+}
+
+// rankIndex returns the index of the laptop in the ranking, or where it goes if it's not ranked
+func (store *InMemoryRatingStore) rankIndex(laptopID string) int {
+	score := store.weightedScore(store.rating[laptopID])
+
+	return sort.Search(len(store.ranking), func(i int) bool {
+		other := store.weightedScore(store.rating[store.ranking[i]])
+		// ties are broken by laptop ID to give every laptop a single place
+		return other < score || (other == score && store.ranking[i] >= laptopID)
+	})
+}
+
+// rank inserts the laptop in the ranking by its current rating
+func (store *InMemoryRatingStore) rank(laptopID string) {
+	i := store.rankIndex(laptopID)
+
+	store.ranking = append(store.ranking, "")
+	copy(store.ranking[i+1:], store.ranking[i:])
+	store.ranking[i] = laptopID
+}
+
+// unrank removes the laptop from the ranking, it must be called before its rating changes
+func (store *InMemoryRatingStore) unrank(laptopID string) {
+	i := store.rankIndex(laptopID)
+	store.ranking = append(store.ranking[:i], store.ranking[i+1:]...)
 }
@@ -0,0 +1,236 @@
+package service_test
+
+import (
+	"fmt"
+	"grpc_youtube_tutorial/service"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInMemoryRatingStoreDistribution(t *testing.T) {
+	t.Parallel()
+
+	store := service.NewInMemoryRatingStore()
+
+	_, err := store.Find("laptop1")
+	require.Equal(t, service.ErrNotFound, err)
+
+	for i, score := range []float64{2, 4, 4, 4, 5, 5, 7, 9} {
+		_, updated, err := store.Add("laptop1", fmt.Sprintf("user%d", i), score)
+		require.NoError(t, err)
+		require.False(t, updated)
+	}
+
+	rating, err := store.Find("laptop1")
+	require.NoError(t, err)
+	require.Equal(t, uint32(8), rating.Count)
+	require.Equal(t, 5.0, rating.Mean())
+	require.InDelta(t, 2.0, rating.StdDev(), 1e-9)
+	require.Equal(t, 4.5, rating.Percentile(0.5))
+	require.Equal(t, 4.0, rating.Percentile(0.25))
+	require.Equal(t, 5.5, rating.Percentile(0.75))
+	require.InDelta(t, 7.6, rating.Percentile(0.9), 1e-9)
+	require.Equal(t, []float64{2, 4, 5, 7, 9}, rating.Scores())
+	require.Equal(t, map[float64]uint32{2: 1, 4: 3, 5: 2, 7: 1, 9: 1}, rating.Histogram)
+
+	// the score of the user is moved in the distribution
+	rating, updated, err := store.Add("laptop1", "user7", 2)
+	require.NoError(t, err)
+	require.True(t, updated)
+	require.Equal(t, uint32(8), rating.Count)
+	require.Equal(t, 4.125, rating.Mean())
+	require.Equal(t, 4.0, rating.Percentile(0.5))
+	require.Equal(t, map[float64]uint32{2: 2, 4: 3, 5: 2, 7: 1}, rating.Histogram)
+
+	// returned ratings are copies
+	rating.Histogram[2] = 100
+	other, err := store.Find("laptop1")
+	require.NoError(t, err)
+	require.Equal(t, uint32(2), other.Histogram[2])
+}
+
+func TestInMemoryRatingStoreAging(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2020, time.June, 1, 0, 0, 0, 0, time.UTC)
+
+	store := service.NewInMemoryRatingStore()
+	store.RecentWindow = 30 * 24 * time.Hour
+	store.HalfLife = 60 * 24 * time.Hour
+	store.Now = func() time.Time { return now }
+
+	_, _, err := store.Add("laptop1", "user1", 10)
+	require.NoError(t, err)
+	_, _, err = store.Add("laptop1", "user2", 8)
+	require.NoError(t, err)
+
+	now = now.Add(60 * 24 * time.Hour)
+
+	rating, _, err := store.Add("laptop1", "user3", 4)
+	require.NoError(t, err)
+	require.Equal(t, uint32(3), rating.Count)
+	require.Equal(t, 22.0/3, rating.Mean())
+	require.Equal(t, uint32(1), rating.RecentCount)
+	require.Equal(t, 4.0, rating.RecentMean())
+	// the scores of 60 days ago weigh half as much
+	require.InDelta(t, (5+4+4)/2.0, rating.DecayedScore, 1e-9)
+
+	// re-rating makes the score recent again
+	rating, _, err = store.Add("laptop1", "user1", 10)
+	require.NoError(t, err)
+	require.Equal(t, uint32(2), rating.RecentCount)
+	require.Equal(t, 7.0, rating.RecentMean())
+	require.InDelta(t, (10+4+4)/2.5, rating.DecayedScore, 1e-9)
+
+	// the aggregates are as of when the rating is found
+	now = now.Add(60 * 24 * time.Hour)
+
+	rating, err = store.Find("laptop1")
+	require.NoError(t, err)
+	require.Zero(t, rating.RecentCount)
+	require.Zero(t, rating.RecentMean())
+	require.InDelta(t, (5+2+2)/1.25, rating.DecayedScore, 1e-9)
+
+	// a score given in the past is only recent if it's in the window
+	rating, _, err = store.AddAt("laptop1", "user2", 6, now.Add(-10*24*time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, uint32(1), rating.RecentCount)
+	require.Equal(t, 6.0, rating.RecentMean())
+
+	rating, _, err = store.AddAt("laptop1", "user3", 2, now.Add(-40*24*time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, uint32(1), rating.RecentCount)
+
+	// removed scores leave the aggregates
+	rating, err = store.Remove("laptop1", "user2")
+	require.NoError(t, err)
+	require.Zero(t, rating.RecentCount)
+	require.InDelta(t, (5+2*math.Exp2(-2.0/3))/(0.5+math.Exp2(-2.0/3)), rating.DecayedScore, 1e-9)
+
+	// without window nor decay, all the scores are recent and weigh the same
+	store = service.NewInMemoryRatingStore()
+	store.RecentWindow = 0
+	store.HalfLife = 0
+	store.Now = func() time.Time { return now }
+
+	addTestRatings(t, store, "laptop1", 10, 8)
+	now = now.Add(1000 * 24 * time.Hour)
+	_, _, err = store.Add("laptop1", "user2", 4)
+	require.NoError(t, err)
+
+	rating, err = store.Find("laptop1")
+	require.NoError(t, err)
+	require.Equal(t, uint32(3), rating.RecentCount)
+	require.Equal(t, rating.Mean(), rating.RecentMean())
+	require.InDelta(t, rating.Mean(), rating.DecayedScore, 1e-9)
+}
+
+func TestInMemoryRatingStoreRemove(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2020, time.June, 1, 0, 0, 0, 0, time.UTC)
+
+	store := service.NewInMemoryRatingStore()
+	store.Now = func() time.Time { return now }
+
+	addTestRatings(t, store, "laptop1", 2, 4, 9)
+	addTestRatings(t, store, "laptop2", 6)
+
+	_, err := store.RatedAt("laptop1", "user3")
+	require.Equal(t, service.ErrNotFound, err)
+
+	// scores can be given in the past
+	_, updated, err := store.AddAt("laptop1", "user3", 7, now.Add(-time.Hour))
+	require.NoError(t, err)
+	require.False(t, updated)
+
+	ratedAt, err := store.RatedAt("laptop1", "user3")
+	require.NoError(t, err)
+	require.Equal(t, now.Add(-time.Hour), ratedAt)
+
+	rating, err := store.Remove("laptop1", "user2")
+	require.NoError(t, err)
+	require.Equal(t, uint32(3), rating.Count)
+	require.Equal(t, 13.0/3, rating.Mean())
+	require.Equal(t, map[float64]uint32{2: 1, 4: 1, 7: 1}, rating.Histogram)
+
+	_, err = store.Remove("laptop1", "user2")
+	require.Equal(t, service.ErrNotFound, err)
+
+	_, err = store.RatedAt("laptop1", "user2")
+	require.Equal(t, service.ErrNotFound, err)
+
+	requireTopRated(t, store, "laptop2", "laptop1")
+
+	// the laptop is no longer rated without scores
+	rating, err = store.Remove("laptop2", "user0")
+	require.NoError(t, err)
+	require.Zero(t, rating.Count)
+
+	_, err = store.Find("laptop2")
+	require.Equal(t, service.ErrNotFound, err)
+
+	requireTopRated(t, store, "laptop1")
+}
+
+func TestInMemoryRatingStoreTopRated(t *testing.T) {
+	t.Parallel()
+
+	store := service.NewInMemoryRatingStore()
+	store.MinVotes = 2
+
+	// a single 10 doesn't beat many 9s
+	addTestRatings(t, store, "single", 10)
+	addTestRatings(t, store, "pair", 10, 10)
+	addTestRatings(t, store, "many", repeatScore(9.1, 500)...)
+	addTestRatings(t, store, "low", repeatScore(3, 50)...)
+	addTestRatings(t, store, "rising", 1, 1, 1)
+
+	requireTopRated(t, store, "many", "pair", "rising", "low")
+
+	// re-rating moves the laptop in the ranking
+	for i := 0; i < 3; i++ {
+		_, updated, err := store.Add("rising", fmt.Sprintf("user%d", i), 10)
+		require.NoError(t, err)
+		require.True(t, updated)
+	}
+	requireTopRated(t, store, "many", "rising", "pair", "low")
+
+	var ranked []*service.RankedRating
+	err := store.TopRated(func(r *service.RankedRating) bool {
+		ranked = append(ranked, r)
+		return len(ranked) < 2
+	})
+	require.NoError(t, err)
+	require.Len(t, ranked, 2)
+	require.Equal(t, uint32(500), ranked[0].Rating.Count)
+	require.InDelta(t, (5.5*10+9.1*500)/510, ranked[0].WeightedScore, 1e-9)
+}
+
+func addTestRatings(t *testing.T, store service.RatingStore, laptopID string, scores ...float64) {
+	for i, score := range scores {
+		_, _, err := store.Add(laptopID, fmt.Sprintf("user%d", i), score)
+		require.NoError(t, err)
+	}
+}
+
+func repeatScore(score float64, n int) []float64 {
+	scores := make([]float64, n)
+	for i := range scores {
+		scores[i] = score
+	}
+	return scores
+}
+
+func requireTopRated(t *testing.T, store service.RatingStore, laptopIDs ...string) {
+	var ranked []string
+	err := store.TopRated(func(r *service.RankedRating) bool {
+		ranked = append(ranked, r.LaptopID)
+		return true
+	})
+	require.NoError(t, err)
+	require.Equal(t, laptopIDs, ranked)
+}
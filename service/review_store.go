@@ -0,0 +1,282 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrReviewNotPending for moderating a review that's already moderated
+var ErrReviewNotPending = errors.New("review is not pending moderation")
+
+// ReviewStore is an interface to store laptop reviews
+type ReviewStore interface {
+	// Save saves the review of a user for a laptop, replacing the user's previous review of the laptop.
+	// It returns the saved review, and the review it replaced if any.
+	Save(review *Review) (*Review, *Review, error)
+	// Find returns the review with the Id of reviewID
+	Find(reviewID string) (*Review, error)
+	// List returns at most limit approved reviews of the laptop with the Id of laptopID, newest first,
+	// skipping offset reviews
+	List(laptopID string, offset int, limit int) ([]*Review, error)
+	// ListPending returns at most limit reviews pending moderation, oldest first, skipping offset reviews
+	ListPending(offset int, limit int) ([]*Review, error)
+	// ListByUser returns the reviews written by the user, whatever their status, newest first
+	ListByUser(username string) ([]*Review, error)
+	// Moderate approves or rejects the pending review with the Id of reviewID and returns it
+	Moderate(reviewID string, approve bool, reason string) (*Review, error)
+	// Delete removes the review with the Id of reviewID
+	Delete(reviewID string) error
+}
+
+// ReviewStatus is the moderation status of a review
+type ReviewStatus int
+
+// Reviews are pending until an admin approves or rejects them
+const (
+	ReviewPending ReviewStatus = iota
+	ReviewApproved
+	ReviewRejected
+)
+
+// Review is a text review a user gave with their rating of a laptop
+type Review struct {
+	ID       string
+	LaptopID string
+	Username string
+	// Score is the score the user rated the laptop with when writing the review
+	Score     float64
+	Title     string
+	Body      string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	Status ReviewStatus
+	// ModerationReason is the reason the moderator gave for approving or rejecting the review
+	ModerationReason string
+}
+
+// reviewKey identifies the review of a user for a laptop
+type reviewKey struct {
+	laptopID string
+	username string
+}
+
+// InMemoryReviewStore stores laptop reviews in memory
+type InMemoryReviewStore struct {
+	mutex   sync.RWMutex
+	reviews map[string]*Review
+	// laptopReviews indexes review IDs by laptop ID, oldest first
+	laptopReviews map[string][]string
+	userReviews   map[reviewKey]string
+}
+
+// NewInMemoryReviewStore returns a new InMemoryReviewStore
+func NewInMemoryReviewStore() *InMemoryReviewStore {
+	return &InMemoryReviewStore{
+		reviews:       make(map[string]*Review),
+		laptopReviews: make(map[string][]string),
+		userReviews:   make(map[reviewKey]string),
+	}
+}
+
+// Save saves the review of a user for a laptop, replacing the user's previous review of the laptop
+func (store *InMemoryReviewStore) Save(review *Review) (*Review, *Review, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	now := time.Now()
+	key := reviewKey{review.LaptopID, review.Username}
+
+	saved := *review
+	saved.UpdatedAt = now
+	saved.Status = ReviewPending
+	saved.ModerationReason = ""
+
+	var replaced *Review
+	if reviewID, ok := store.userReviews[key]; ok {
+		previous := *store.reviews[reviewID]
+		replaced = &previous
+
+		saved.ID = reviewID
+		saved.CreatedAt = previous.CreatedAt
+	} else {
+		reviewID, err := uuid.NewRandom()
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot generate review id: %v", err)
+		}
+
+		saved.ID = reviewID.String()
+		saved.CreatedAt = now
+
+		store.userReviews[key] = saved.ID
+	}
+
+	// a replaced review moves to the end of the laptop reviews, and of the moderation queue
+	store.moveToEnd(saved.LaptopID, saved.ID)
+	store.reviews[saved.ID] = &saved
+
+	other := saved
+	return &other, replaced, nil
+}
+
+// moveToEnd moves the review to the end of the laptop reviews, it must be called with the write lock held
+func (store *InMemoryReviewStore) moveToEnd(laptopID string, reviewID string) {
+	reviewIDs := store.laptopReviews[laptopID]
+	for i, id := range reviewIDs {
+		if id == reviewID {
+			reviewIDs = append(reviewIDs[:i:i], reviewIDs[i+1:]...)
+			break
+		}
+	}
+	store.laptopReviews[laptopID] = append(reviewIDs, reviewID)
+}
+
+// Find returns the review with the Id of reviewID
+func (store *InMemoryReviewStore) Find(reviewID string) (*Review, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	review := store.reviews[reviewID]
+	if review == nil {
+		return nil, ErrNotFound
+	}
+
+	other := *review
+	return &other, nil
+}
+
+// List returns at most limit approved reviews of the laptop with the Id of laptopID, newest first,
+// skipping offset reviews
+func (store *InMemoryReviewStore) List(laptopID string, offset int, limit int) ([]*Review, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	reviewIDs := store.laptopReviews[laptopID]
+
+	var reviews []*Review
+	for i := len(reviewIDs) - 1; i >= 0 && len(reviews) < limit; i-- {
+		review := store.reviews[reviewIDs[i]]
+		if review.Status != ReviewApproved {
+			continue
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+
+		other := *review
+		reviews = append(reviews, &other)
+	}
+
+	return reviews, nil
+}
+
+// ListPending returns at most limit reviews pending moderation, oldest first, skipping offset reviews
+func (store *InMemoryReviewStore) ListPending(offset int, limit int) ([]*Review, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	var reviews []*Review
+	for _, review := range store.reviews {
+		if review.Status == ReviewPending {
+			other := *review
+			reviews = append(reviews, &other)
+		}
+	}
+
+	sort.Slice(reviews, func(i, j int) bool {
+		if !reviews[i].UpdatedAt.Equal(reviews[j].UpdatedAt) {
+			return reviews[i].UpdatedAt.Before(reviews[j].UpdatedAt)
+		}
+		return reviews[i].ID < reviews[j].ID
+	})
+
+	if offset >= len(reviews) {
+		return nil, nil
+	}
+	reviews = reviews[offset:]
+	if len(reviews) > limit {
+		reviews = reviews[:limit]
+	}
+
+	return reviews, nil
+}
+
+// ListByUser returns the reviews written by the user, whatever their status, newest first
+func (store *InMemoryReviewStore) ListByUser(username string) ([]*Review, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	var reviews []*Review
+	for key, reviewID := range store.userReviews {
+		if key.username == username {
+			other := *store.reviews[reviewID]
+			reviews = append(reviews, &other)
+		}
+	}
+
+	sort.Slice(reviews, func(i, j int) bool {
+		return reviews[i].UpdatedAt.After(reviews[j].UpdatedAt)
+	})
+
+	return reviews, nil
+}
+
+// Moderate approves or rejects the pending review with the Id of reviewID and returns it
+func (store *InMemoryReviewStore) Moderate(reviewID string, approve bool, reason string) (*Review, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	review := store.reviews[reviewID]
+	if review == nil {
+		return nil, ErrNotFound
+	}
+
+	if review.Status != ReviewPending {
+		return nil, ErrReviewNotPending
+	}
+
+	review.Status = ReviewRejected
+	if approve {
+		review.Status = ReviewApproved
+	}
+	review.ModerationReason = reason
+
+	other := *review
+	return &other, nil
+}
+
+// Delete removes the review with the Id of reviewID
+func (store *InMemoryReviewStore) Delete(reviewID string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	review := store.reviews[reviewID]
+	if review == nil {
+		return ErrNotFound
+	}
+
+	delete(store.reviews, reviewID)
+	delete(store.userReviews, reviewKey{review.LaptopID, review.Username})
+
+	reviewIDs := store.laptopReviews[review.LaptopID]
+	for i, id := range reviewIDs {
+		if id == reviewID {
+			reviewIDs = append(reviewIDs[:i:i], reviewIDs[i+1:]...)
+			break
+		}
+	}
+
+	if len(reviewIDs) == 0 {
+		delete(store.laptopReviews, review.LaptopID)
+	} else {
+		store.laptopReviews[review.LaptopID] = reviewIDs
+	}
+
+	return nil
+}
@@ -0,0 +1,150 @@
+package service_test
+
+import (
+	"errors"
+	"fmt"
+	"grpc_youtube_tutorial/service"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInMemoryReviewStore(t *testing.T) {
+	t.Parallel()
+
+	store := service.NewInMemoryReviewStore()
+
+	reviewIDs := make([]string, 5)
+	for i := range reviewIDs {
+		review, replaced, err := store.Save(&service.Review{
+			LaptopID: "laptop1",
+			Username: fmt.Sprintf("user%d", i),
+			Score:    float64(i + 1),
+			Title:    fmt.Sprintf("review %d", i),
+		})
+		require.NoError(t, err)
+		require.NotEmpty(t, review.ID)
+		require.Nil(t, replaced)
+		reviewIDs[i] = review.ID
+	}
+
+	// a user has one review per laptop
+	updated, replaced, err := store.Save(&service.Review{LaptopID: "laptop1", Username: "user1", Score: 9, Title: "changed my mind"})
+	require.NoError(t, err)
+	require.Equal(t, reviewIDs[1], updated.ID)
+	require.Equal(t, reviewIDs[1], replaced.ID)
+	require.Equal(t, 2.0, replaced.Score)
+	require.False(t, updated.UpdatedAt.Before(updated.CreatedAt))
+
+	found, err := store.Find(reviewIDs[1])
+	require.NoError(t, err)
+	require.Equal(t, "changed my mind", found.Title)
+	require.Equal(t, 9.0, found.Score)
+	require.Equal(t, service.ReviewPending, found.Status)
+
+	// only approved reviews are listed
+	page, err := store.List("laptop1", 0, 10)
+	require.NoError(t, err)
+	require.Empty(t, page)
+
+	pending, err := store.ListPending(0, 10)
+	require.NoError(t, err)
+	require.Len(t, pending, 5)
+
+	for _, reviewID := range reviewIDs {
+		moderated, err := store.Moderate(reviewID, true, "")
+		require.NoError(t, err)
+		require.Equal(t, service.ReviewApproved, moderated.Status)
+	}
+
+	_, err = store.Moderate(reviewIDs[0], false, "too late")
+	require.Equal(t, service.ErrReviewNotPending, err)
+
+	_, err = store.Moderate("unknown", true, "")
+	require.Equal(t, service.ErrNotFound, err)
+
+	pending, err = store.ListPending(0, 10)
+	require.NoError(t, err)
+	require.Empty(t, pending)
+
+	// newest first, in pages
+	page, err = store.List("laptop1", 0, 2)
+	require.NoError(t, err)
+	requireReviewIDs(t, page, reviewIDs[1], reviewIDs[4])
+
+	page, err = store.List("laptop1", 4, 2)
+	require.NoError(t, err)
+	requireReviewIDs(t, page, reviewIDs[0])
+
+	// an edited review goes back to moderation
+	_, replaced, err = store.Save(&service.Review{LaptopID: "laptop1", Username: "user2", Score: 1, Title: "broke"})
+	require.NoError(t, err)
+	require.Equal(t, service.ReviewApproved, replaced.Status)
+	require.NoError(t, err)
+
+	rejected, err := store.Moderate(reviewIDs[2], false, "off topic")
+	require.NoError(t, err)
+	require.Equal(t, service.ReviewRejected, rejected.Status)
+	require.Equal(t, "off topic", rejected.ModerationReason)
+
+	byUser, err := store.ListByUser("user2")
+	require.NoError(t, err)
+	requireReviewIDs(t, byUser, reviewIDs[2])
+	require.Equal(t, service.ReviewRejected, byUser[0].Status)
+
+	require.NoError(t, store.Delete(reviewIDs[3]))
+	require.Equal(t, service.ErrNotFound, store.Delete(reviewIDs[3]))
+
+	page, err = store.List("laptop1", 0, 10)
+	require.NoError(t, err)
+	requireReviewIDs(t, page, reviewIDs[1], reviewIDs[4], reviewIDs[0])
+
+	page, err = store.List("laptop2", 0, 10)
+	require.NoError(t, err)
+	require.Empty(t, page)
+}
+
+func requireReviewIDs(t *testing.T, reviews []*service.Review, reviewIDs ...string) {
+	require.Len(t, reviews, len(reviewIDs))
+	for i, review := range reviews {
+		require.Equal(t, reviewIDs[i], review.ID)
+	}
+}
+
+func TestReviewValidators(t *testing.T) {
+	t.Parallel()
+
+	length := service.ReviewLengthValidator(10, 20)
+	profanity := service.ProfanityValidator("Darn", "heck")
+
+	testCases := []struct {
+		name      string
+		validator service.ReviewValidator
+		review    *service.Review
+		valid     bool
+	}{
+		{name: "short", validator: length, review: &service.Review{Title: "Great", Body: "Fast and light"}, valid: true},
+		{name: "multibyte_title", validator: length, review: &service.Review{Title: "très très"}, valid: true},
+		{name: "long_title", validator: length, review: &service.Review{Title: "A great laptop"}},
+		{name: "long_body", validator: length, review: &service.Review{Body: strings.Repeat("a", 21)}},
+		{name: "clean", validator: profanity, review: &service.Review{Title: "Hecktic week", Body: "Darned good"}, valid: true},
+		{name: "banned_title", validator: profanity, review: &service.Review{Title: "DARN it"}},
+		{name: "banned_body", validator: profanity, review: &service.Review{Body: "what the heck!"}},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := tc.validator(tc.review)
+			if tc.valid {
+				require.NoError(t, err)
+			} else {
+				require.True(t, errors.Is(err, service.ErrInvalidReview))
+			}
+		})
+	}
+}
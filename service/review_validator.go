@@ -0,0 +1,57 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ErrInvalidReview for reviews rejected by a ReviewValidator
+var ErrInvalidReview = errors.New("invalid review")
+
+// ReviewValidator checks a review before it's saved, returning an error wrapping ErrInvalidReview if it's rejected
+type ReviewValidator func(review *Review) error
+
+// Default maximum review lengths, in characters
+const (
+	defaultMaxReviewTitleLength = 100
+	defaultMaxReviewBodyLength  = 5000
+)
+
+// ReviewLengthValidator rejects reviews with a title or body longer than the given number of characters
+func ReviewLengthValidator(maxTitleLength int, maxBodyLength int) ReviewValidator {
+	return func(review *Review) error {
+		if utf8.RuneCountInString(review.Title) > maxTitleLength {
+			return fmt.Errorf("%w: title must be at most %d characters", ErrInvalidReview, maxTitleLength)
+		}
+		if utf8.RuneCountInString(review.Body) > maxBodyLength {
+			return fmt.Errorf("%w: body must be at most %d characters", ErrInvalidReview, maxBodyLength)
+		}
+		return nil
+	}
+}
+
+// ProfanityValidator rejects reviews containing any of the given words, ignoring case
+func ProfanityValidator(words ...string) ReviewValidator {
+	banned := make(map[string]bool, len(words))
+	for _, word := range words {
+		banned[strings.ToLower(word)] = true
+	}
+
+	return func(review *Review) error {
+		for _, text := range []string{review.Title, review.Body} {
+			for _, word := range strings.FieldsFunc(strings.ToLower(text), isNotWordRune) {
+				if banned[word] {
+					return fmt.Errorf("%w: review contains a banned word", ErrInvalidReview)
+				}
+			}
+		}
+		return nil
+	}
+}
+
+func isNotWordRune(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+}
@@ -3,32 +3,69 @@ package service
 import (
 	"errors"
 	"fmt"
+	"grpc_youtube_tutorial/memory"
 	"grpc_youtube_tutorial/pb"
 	"sync"
 
-	"github.com/jinzhu/copier"
+	"github.com/golang/protobuf/proto"
 )
 
 // ErrAlreadyExists for records that already exists
 var ErrAlreadyExists = errors.New("record already exists")
 
+// ErrNotFound for records that don't exist
+var ErrNotFound = errors.New("record not found")
+
 // LaptopStore is an interface for laptop storage
 type LaptopStore interface {
 	Save(laptop *pb.Laptop) error
+	// SaveAll saves either all of the laptops or none of them, returning a *SaveAllError
+	// for the first laptop that can't be saved
+	SaveAll(laptops []*pb.Laptop) error
 	Find(laptopID string) (*pb.Laptop, bool)
 	Search(filter *pb.Filter, found func(laptop *pb.Laptop) error) error
 }
 
+// SaveAllError is the error of the laptop that stopped SaveAll
+type SaveAllError struct {
+	// Index of the laptop in the laptops given to SaveAll
+	Index int
+	Err   error
+}
+
+func (err *SaveAllError) Error() string {
+	return fmt.Sprintf("cannot save laptop %d: %v", err.Index, err.Err)
+}
+
+// Unwrap returns the error of the laptop
+func (err *SaveAllError) Unwrap() error {
+	return err.Err
+}
+
+// EncodedLaptopStore is implemented by laptop stores that can hand out laptops
+// in their marshalled form, avoiding a copy per result
+type EncodedLaptopStore interface {
+	// SearchEncoded works like Search, but the callback receives the stored
+	// wire encoding of each laptop. The bytes are shared and must not be modified.
+	SearchEncoded(filter *pb.Filter, found func(laptopID string, data []byte) error) error
+}
+
 // InMemoryLaptopStore in-memory laptop storage
 type InMemoryLaptopStore struct {
 	mutex sync.RWMutex
-	data  map[string]*pb.Laptop
+	data  map[string]*storedLaptop
+}
+
+// storedLaptop is a laptop together with its wire encoding. Neither is modified after Save.
+type storedLaptop struct {
+	laptop  *pb.Laptop
+	encoded []byte
 }
 
 // NewInMemoryLaptopStore returns a InMemoryLaptopStore
 func NewInMemoryLaptopStore() *InMemoryLaptopStore {
 	return &InMemoryLaptopStore{
-		data: make(map[string]*pb.Laptop),
+		data: make(map[string]*storedLaptop),
 	}
 }
 
@@ -41,56 +78,112 @@ func (store *InMemoryLaptopStore) Save(laptop *pb.Laptop) error {
 		return ErrAlreadyExists
 	}
 
-	// deep copy
-	other := &pb.Laptop{}
-	err := copier.Copy(other, laptop)
+	stored, err := newStoredLaptop(laptop)
 	if err != nil {
-		return fmt.Errorf("unable to copy laptop: %v", err)
+		return err
+	}
+
+	store.data[laptop.Id] = stored
+
+	return nil
+}
+
+// SaveAll saves either all of the laptops or none of them, returning a *SaveAllError
+// for the first laptop that can't be saved
+func (store *InMemoryLaptopStore) SaveAll(laptops []*pb.Laptop) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	batch := make(map[string]*storedLaptop, len(laptops))
+	for i, laptop := range laptops {
+		if store.data[laptop.Id] != nil || batch[laptop.Id] != nil {
+			return &SaveAllError{Index: i, Err: ErrAlreadyExists}
+		}
+
+		stored, err := newStoredLaptop(laptop)
+		if err != nil {
+			return &SaveAllError{Index: i, Err: err}
+		}
+
+		batch[laptop.Id] = stored
 	}
 
-	store.data[other.Id] = other
+	for laptopID, stored := range batch {
+		store.data[laptopID] = stored
+	}
 
 	return nil
 }
 
+// newStoredLaptop returns a copy of the laptop with its encoding
+func newStoredLaptop(laptop *pb.Laptop) (*storedLaptop, error) {
+	other := deepCopy(laptop)
+
+	data, err := proto.Marshal(other)
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal laptop: %v", err)
+	}
+
+	return &storedLaptop{
+		laptop:  other,
+		encoded: data,
+	}, nil
+}
+
 // Find checks if laptop with the Id of laptopID is in the store
 func (store *InMemoryLaptopStore) Find(laptopID string) (*pb.Laptop, bool) {
 	store.mutex.RLock()
 	defer store.mutex.RUnlock()
 
-	laptop := store.data[laptopID]
+	stored := store.data[laptopID]
 
-	if laptop == nil {
+	if stored == nil {
 		return nil, false
 	}
 
-	other, err := deepCopy(laptop)
-	if err != nil {
-		return nil, false
+	return deepCopy(stored.laptop), true
+}
+
+// Search takes a filter and a callback function which will be called if laptop(s) are found.
+// The callback runs outside the store lock, on a snapshot of the matching laptops.
+func (store *InMemoryLaptopStore) Search(filter *pb.Filter, found func(laptop *pb.Laptop) error) error {
+	for _, stored := range store.snapshot(filter) {
+		err := found(deepCopy(stored.laptop))
+		if err != nil {
+			return err
+		}
 	}
 
-	return other, true
+	return nil
 }
 
-// Search takes a filter and a callback function which will be called if laptop(s) are found
-func (store *InMemoryLaptopStore) Search(filter *pb.Filter, found func(laptop *pb.Laptop) error) error {
+// SearchEncoded takes a filter and a callback function which will be called with the
+// encoded bytes of every matching laptop. The callback runs outside the store lock.
+func (store *InMemoryLaptopStore) SearchEncoded(filter *pb.Filter, found func(laptopID string, data []byte) error) error {
+	for _, stored := range store.snapshot(filter) {
+		err := found(stored.laptop.GetId(), stored.encoded)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// snapshot returns the stored laptops matching the filter. Stored laptops are never
+// modified after Save, so the returned pointers stay valid once the lock is released.
+func (store *InMemoryLaptopStore) snapshot(filter *pb.Filter) []*storedLaptop {
 	store.mutex.RLock()
 	defer store.mutex.RUnlock()
 
-	for _, laptop := range store.data {
-		if isQualified(filter, laptop) {
-			other, err := deepCopy(laptop)
-			if err != nil {
-				return err
-			}
-			err = found(other)
-			if err != nil {
-				return err
-			}
+	laptops := make([]*storedLaptop, 0, len(store.data))
+	for _, stored := range store.data {
+		if isQualified(filter, stored.laptop) {
+			laptops = append(laptops, stored)
 		}
 	}
 
-	return nil
+	return laptops
 }
 
 func isQualified(filter *pb.Filter, laptop *pb.Laptop) bool {
@@ -106,7 +199,7 @@ func isQualified(filter *pb.Filter, laptop *pb.Laptop) bool {
 		return false
 	}
 
-	if toBit(laptop.GetRam()) < toBit(filter.GetMinMemory()) {
+	if memory.Compare(laptop.GetRam(), filter.GetMinMemory()) < 0 {
 		return false
 	}
 
@@ -114,32 +207,6 @@ func isQualified(filter *pb.Filter, laptop *pb.Laptop) bool {
 
 }
 
-func toBit(memory *pb.Memory) uint64 {
-	value := uint64(memory.GetValue())
-
-	switch memory.GetUnit() {
-	case pb.Memory_BIT:
-		return value
-	case pb.Memory_BYTE:
-		return value << 3 // 8 = 2^3
-	case pb.Memory_KILOBYTE:
-		return value << 13 // 1024 = 2^10 8 = 2^3
-	case pb.Memory_MEGABYTE:
-		return value << 23
-	case pb.Memory_GIGABYTE:
-		return value << 33
-	case pb.Memory_TERABYTE:
-		return value << 43
-	default:
-		return 0
-	}
-}
-
-func deepCopy(laptop *pb.Laptop) (*pb.Laptop, error) {
-	other := &pb.Laptop{}
-	err := copier.Copy(other, laptop)
-	if err != nil {
-		return nil, err
-	}
-	return other, nil
+func deepCopy(laptop *pb.Laptop) *pb.Laptop {
+	return proto.Clone(laptop).(*pb.Laptop)
 }
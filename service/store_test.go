@@ -0,0 +1,115 @@
+package service_test
+
+import (
+	"errors"
+	"grpc_youtube_tutorial/pb"
+	"grpc_youtube_tutorial/sample"
+	"grpc_youtube_tutorial/service"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInMemoryLaptopStoreSearchEncoded(t *testing.T) {
+	t.Parallel()
+
+	store := service.NewInMemoryLaptopStore()
+	laptop := sample.NewLaptop()
+	err := store.Save(laptop)
+	require.NoError(t, err)
+
+	filter := &pb.Filter{MaxPriceUsd: 5000}
+
+	found := 0
+	err = store.SearchEncoded(filter, func(laptopID string, data []byte) error {
+		other := &pb.Laptop{}
+		err := proto.Unmarshal(data, other)
+		require.NoError(t, err)
+		require.Equal(t, laptop.GetId(), laptopID)
+		require.True(t, proto.Equal(laptop, other))
+
+		found++
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, found)
+}
+
+func newBenchmarkLaptopStore(b *testing.B, n int) *service.InMemoryLaptopStore {
+	store := service.NewInMemoryLaptopStore()
+	for i := 0; i < n; i++ {
+		err := store.Save(sample.NewLaptop())
+		require.NoError(b, err)
+	}
+	return store
+}
+
+// BenchmarkSearch measures the default search path: every match is cloned
+// and then marshalled, as gRPC does when the response is sent
+func BenchmarkSearch(b *testing.B) {
+	store := newBenchmarkLaptopStore(b, 1000)
+	filter := &pb.Filter{MaxPriceUsd: 5000}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := store.Search(filter, func(laptop *pb.Laptop) error {
+			_, err := proto.Marshal(&pb.SearchLaptopResponse{Laptop: laptop})
+			return err
+		})
+		require.NoError(b, err)
+	}
+}
+
+// BenchmarkSearchEncoded measures the read-only search path, which hands out
+// the bytes encoded once on save
+func BenchmarkSearchEncoded(b *testing.B) {
+	store := newBenchmarkLaptopStore(b, 1000)
+	filter := &pb.Filter{MaxPriceUsd: 5000}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := store.SearchEncoded(filter, func(laptopID string, data []byte) error {
+			_ = append([]byte{}, data...)
+			return nil
+		})
+		require.NoError(b, err)
+	}
+}
+
+func TestInMemoryLaptopStoreSaveAll(t *testing.T) {
+	t.Parallel()
+
+	store := service.NewInMemoryLaptopStore()
+
+	existing := sample.NewLaptop()
+	require.NoError(t, store.Save(existing))
+
+	batch := []*pb.Laptop{sample.NewLaptop(), sample.NewLaptop(), existing}
+	duplicates := []*pb.Laptop{sample.NewLaptop(), batch[0], batch[0]}
+
+	for _, laptops := range [][]*pb.Laptop{batch, duplicates} {
+		err := store.SaveAll(laptops)
+
+		var saveAllErr *service.SaveAllError
+		require.True(t, errors.As(err, &saveAllErr))
+		require.Equal(t, 2, saveAllErr.Index)
+		require.True(t, errors.Is(err, service.ErrAlreadyExists))
+
+		// none of the laptops is saved
+		for _, laptop := range laptops[:2] {
+			_, found := store.Find(laptop.GetId())
+			require.False(t, found)
+		}
+	}
+
+	err := store.SaveAll(batch[:2])
+	require.NoError(t, err)
+
+	for _, laptop := range batch {
+		_, found := store.Find(laptop.GetId())
+		require.True(t, found)
+	}
+}
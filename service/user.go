@@ -2,6 +2,7 @@ package service
 
 import (
 	"fmt"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
 )
@@ -11,6 +12,7 @@ type User struct {
 	Username       string
 	HashedPassword string
 	Role           string
+	CreatedAt      time.Time
 }
 
 // NewUser returns a new user
@@ -24,6 +26,7 @@ func NewUser(username string, password string, role string) (*User, error) {
 		Username:       username,
 		HashedPassword: string(hashedPassword),
 		Role:           role,
+		CreatedAt:      time.Now(),
 	}
 
 	return user, nil
@@ -41,5 +44,6 @@ func (user *User) Clone() *User {
 		Username:       user.Username,
 		HashedPassword: user.HashedPassword,
 		Role:           user.Role,
+		CreatedAt:      user.CreatedAt,
 	}
 }
@@ -0,0 +1,251 @@
+package validator
+
+import (
+	"fmt"
+	"grpc_youtube_tutorial/pb"
+	"strings"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Violations is a list of field violations found in a message
+type Violations []*errdetails.BadRequest_FieldViolation
+
+func (violations *Violations) add(field string, description string) {
+	*violations = append(*violations, &errdetails.BadRequest_FieldViolation{
+		Field:       field,
+		Description: description,
+	})
+}
+
+// Err returns an InvalidArgument status error with the violations attached as
+// google.rpc.BadRequest details, or nil if there are no violations
+func (violations Violations) Err(message string) error {
+	if len(violations) == 0 {
+		return nil
+	}
+
+	descriptions := make([]string, len(violations))
+	for i, violation := range violations {
+		descriptions[i] = fmt.Sprintf("%s: %s", violation.GetField(), violation.GetDescription())
+	}
+
+	st := status.Newf(codes.InvalidArgument, "%s: %s", message, strings.Join(descriptions, "; "))
+
+	detailed, err := st.WithDetails(&errdetails.BadRequest{FieldViolations: violations})
+	if err != nil {
+		return st.Err()
+	}
+
+	return detailed.Err()
+}
+
+// ValidateLaptop returns an InvalidArgument status error listing every violation in laptop,
+// or nil if the laptop is valid
+func ValidateLaptop(laptop *pb.Laptop) error {
+	return LaptopViolations(laptop, "laptop").Err("laptop is invalid")
+}
+
+// LaptopViolations returns the violations in laptop, with field paths prefixed by field
+func LaptopViolations(laptop *pb.Laptop, field string) Violations {
+	violations := Violations{}
+
+	if laptop == nil {
+		violations.add(field, "is required")
+		return violations
+	}
+
+	if laptop.GetBrand() == "" {
+		violations.add(field+".brand", "is required")
+	}
+
+	if laptop.GetName() == "" {
+		violations.add(field+".name", "is required")
+	}
+
+	violations = append(violations, CPUViolations(laptop.GetCpu(), field+".cpu")...)
+	violations = append(violations, MemoryViolations(laptop.GetRam(), field+".ram")...)
+
+	for i, gpu := range laptop.GetGpus() {
+		violations = append(violations, GPUViolations(gpu, fmt.Sprintf("%s.gpus[%d]", field, i))...)
+	}
+
+	for i, storage := range laptop.GetStorages() {
+		violations = append(violations, StorageViolations(storage, fmt.Sprintf("%s.storages[%d]", field, i))...)
+	}
+
+	violations = append(violations, ScreenViolations(laptop.GetScreen(), field+".screen")...)
+	violations = append(violations, KeyboardViolations(laptop.GetKeyboard(), field+".keyboard")...)
+
+	switch weight := laptop.GetWeight().(type) {
+	case *pb.Laptop_WeightKg:
+		if weight.WeightKg <= 0 {
+			violations.add(field+".weight_kg", "must be greater than 0")
+		}
+	case *pb.Laptop_WeightLb:
+		if weight.WeightLb <= 0 {
+			violations.add(field+".weight_lb", "must be greater than 0")
+		}
+	}
+
+	if laptop.GetPriceUsd() < 0 {
+		violations.add(field+".price_usd", "must not be negative")
+	}
+
+	return violations
+}
+
+// CPUViolations returns the violations in cpu, with field paths prefixed by field
+func CPUViolations(cpu *pb.CPU, field string) Violations {
+	violations := Violations{}
+
+	if cpu == nil {
+		violations.add(field, "is required")
+		return violations
+	}
+
+	if cpu.GetBrand() == "" {
+		violations.add(field+".brand", "is required")
+	}
+
+	if cpu.GetName() == "" {
+		violations.add(field+".name", "is required")
+	}
+
+	if cpu.GetNumberCores() == 0 {
+		violations.add(field+".number_cores", "must be greater than 0")
+	}
+
+	if cpu.GetNumberThreads() < cpu.GetNumberCores() {
+		violations.add(field+".number_threads", "must not be less than number_cores")
+	}
+
+	violations = append(violations, frequencyViolations(cpu.GetMinGhz(), cpu.GetMaxGhz(), field)...)
+
+	return violations
+}
+
+// GPUViolations returns the violations in gpu, with field paths prefixed by field
+func GPUViolations(gpu *pb.GPU, field string) Violations {
+	violations := Violations{}
+
+	if gpu == nil {
+		violations.add(field, "is required")
+		return violations
+	}
+
+	if gpu.GetBrand() == "" {
+		violations.add(field+".brand", "is required")
+	}
+
+	if gpu.GetName() == "" {
+		violations.add(field+".name", "is required")
+	}
+
+	violations = append(violations, frequencyViolations(gpu.GetMinGhz(), gpu.GetMaxGhz(), field)...)
+	violations = append(violations, MemoryViolations(gpu.GetMemory(), field+".memory")...)
+
+	return violations
+}
+
+func frequencyViolations(minGhz float32, maxGhz float32, field string) Violations {
+	violations := Violations{}
+
+	if minGhz <= 0 {
+		violations.add(field+".min_ghz", "must be greater than 0")
+	}
+
+	if maxGhz < minGhz {
+		violations.add(field+".max_ghz", "must not be less than min_ghz")
+	}
+
+	return violations
+}
+
+// MemoryViolations returns the violations in memory, with field paths prefixed by field
+func MemoryViolations(memory *pb.Memory, field string) Violations {
+	violations := Violations{}
+
+	if memory == nil {
+		violations.add(field, "is required")
+		return violations
+	}
+
+	if memory.GetValue() == 0 {
+		violations.add(field+".value", "must be greater than 0")
+	}
+
+	if memory.GetUnit() == pb.Memory_UNKNOWN {
+		violations.add(field+".unit", "must be specified")
+	}
+
+	return violations
+}
+
+// StorageViolations returns the violations in storage, with field paths prefixed by field
+func StorageViolations(storage *pb.Storage, field string) Violations {
+	violations := Violations{}
+
+	if storage == nil {
+		violations.add(field, "is required")
+		return violations
+	}
+
+	if storage.GetDriver() == pb.Storage_UNKNOWN {
+		violations.add(field+".driver", "must be specified")
+	}
+
+	violations = append(violations, MemoryViolations(storage.GetMemory(), field+".memory")...)
+
+	return violations
+}
+
+// ScreenViolations returns the violations in screen, with field paths prefixed by field
+func ScreenViolations(screen *pb.Screen, field string) Violations {
+	violations := Violations{}
+
+	if screen == nil {
+		violations.add(field, "is required")
+		return violations
+	}
+
+	if screen.GetSizeInch() <= 0 {
+		violations.add(field+".size_inch", "must be greater than 0")
+	}
+
+	resolution := screen.GetResolution()
+	if resolution == nil {
+		violations.add(field+".resolution", "is required")
+	} else {
+		if resolution.GetWidth() == 0 {
+			violations.add(field+".resolution.width", "must be greater than 0")
+		}
+		if resolution.GetHeight() == 0 {
+			violations.add(field+".resolution.height", "must be greater than 0")
+		}
+	}
+
+	if screen.GetPanel() == pb.Screen_UNKNOWN {
+		violations.add(field+".panel", "must be specified")
+	}
+
+	return violations
+}
+
+// KeyboardViolations returns the violations in keyboard, with field paths prefixed by field
+func KeyboardViolations(keyboard *pb.Keyboard, field string) Violations {
+	violations := Violations{}
+
+	if keyboard == nil {
+		violations.add(field, "is required")
+		return violations
+	}
+
+	if keyboard.GetLayout() == pb.Keyboard_UNKOWN {
+		violations.add(field+".layout", "must be specified")
+	}
+
+	return violations
+}
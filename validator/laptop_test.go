@@ -0,0 +1,86 @@
+package validator_test
+
+import (
+	"grpc_youtube_tutorial/pb"
+	"grpc_youtube_tutorial/sample"
+	"grpc_youtube_tutorial/validator"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestValidateLaptop(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name   string
+		modify func(laptop *pb.Laptop)
+		fields []string
+	}{
+		{
+			name:   "valid",
+			modify: func(laptop *pb.Laptop) {},
+		}, {
+			name:   "negative_price",
+			modify: func(laptop *pb.Laptop) { laptop.PriceUsd = -1 },
+			fields: []string{"laptop.price_usd"},
+		}, {
+			name: "invalid_cpu",
+			modify: func(laptop *pb.Laptop) {
+				laptop.Cpu.NumberCores = 0
+				laptop.Cpu.MinGhz = 3.0
+				laptop.Cpu.MaxGhz = 2.0
+			},
+			fields: []string{"laptop.cpu.number_cores", "laptop.cpu.max_ghz"},
+		}, {
+			name: "fewer_threads_than_cores",
+			modify: func(laptop *pb.Laptop) {
+				laptop.Cpu.NumberCores = 8
+				laptop.Cpu.NumberThreads = 4
+			},
+			fields: []string{"laptop.cpu.number_threads"},
+		}, {
+			name:   "unknown_memory_unit",
+			modify: func(laptop *pb.Laptop) { laptop.Gpus[1].Memory.Unit = pb.Memory_UNKNOWN },
+			fields: []string{"laptop.gpus[1].memory.unit"},
+		}, {
+			name:   "no_screen",
+			modify: func(laptop *pb.Laptop) { laptop.Screen = nil },
+			fields: []string{"laptop.screen"},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			laptop := sample.NewLaptop()
+			tc.modify(laptop)
+
+			err := validator.ValidateLaptop(laptop)
+			if len(tc.fields) == 0 {
+				require.NoError(t, err)
+				return
+			}
+
+			st, ok := status.FromError(err)
+			require.True(t, ok)
+			require.Equal(t, codes.InvalidArgument, st.Code())
+			require.Len(t, st.Details(), 1)
+
+			badRequest, ok := st.Details()[0].(*errdetails.BadRequest)
+			require.True(t, ok)
+
+			fields := make([]string, len(badRequest.GetFieldViolations()))
+			for i, violation := range badRequest.GetFieldViolations() {
+				fields[i] = violation.GetField()
+			}
+			require.Equal(t, tc.fields, fields)
+		})
+	}
+}